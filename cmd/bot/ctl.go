@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/ankogit/4duk-discord-bot/internal/config"
+)
+
+const ctlUsage = `Usage: bot ctl <command>
+
+Commands:
+  status          summary of the running bot and its guilds
+  stop <guild>    stop the radio in a guild
+  reload          read the configuration and the saved guild settings again
+  dump-state      full state of the running bot as JSON
+  crashes         saved crash reports, newest first
+  crash <id>      a saved crash report as JSON
+`
+
+// runCtl sends a command to the running bot over its control socket
+// Returns the exit code
+func runCtl(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, ctlUsage)
+		return 2
+	}
+
+	var method, path string
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		method, path = http.MethodGet, "/status"
+	case args[0] == "stop" && len(args) == 2:
+		method, path = http.MethodPost, "/stop?guild="+url.QueryEscape(args[1])
+	case args[0] == "reload" && len(args) == 1:
+		method, path = http.MethodPost, "/reload"
+	case args[0] == "dump-state" && len(args) == 1:
+		method, path = http.MethodGet, "/dump-state"
+	case args[0] == "crashes" && len(args) == 1:
+		method, path = http.MethodGet, "/crashes"
+	case args[0] == "crash" && len(args) == 2:
+		method, path = http.MethodGet, "/crash?id="+url.QueryEscape(args[1])
+	default:
+		fmt.Fprint(os.Stderr, ctlUsage)
+		return 2
+	}
+
+	socket := config.ControlSocketPath()
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	// The host is ignored, every request goes to the socket
+	req, err := http.NewRequest(method, "http://bot"+path, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to reach the bot on %s, is it running? %v\n", socket, err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	out := os.Stdout
+	if resp.StatusCode >= 300 {
+		out = os.Stderr
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if resp.StatusCode >= 300 {
+		return 1
+	}
+	return 0
+}
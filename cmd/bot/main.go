@@ -7,6 +7,8 @@ import (
 	"runtime/debug"
 	"syscall"
 	"time"
+	// Embedded time zone database for auto-connect schedules (the runtime image has none)
+	_ "time/tzdata"
 
 	"github.com/sirupsen/logrus"
 
@@ -15,6 +17,19 @@ import (
 )
 
 func main() {
+	// Subcommands run instead of the bot
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ctl":
+			// Talks to a running bot
+			os.Exit(runCtl(os.Args[2:]))
+		case "probe":
+			os.Exit(runProbe(os.Args[2:]))
+		case "validate-config":
+			os.Exit(runValidateConfig(os.Args[2:]))
+		}
+	}
+
 	// Setup logger
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.TextFormatter{
@@ -28,83 +43,134 @@ func main() {
 		logger.WithError(err).Fatal("Failed to load configuration")
 	}
 
+	configureLogger(logger, cfg)
+	logConfig(logger, cfg)
+
 	// Run bot with automatic restart on panic
 	// This handles panics from discordgo fork
 	runBotWithRecovery(cfg, logger)
 }
 
+// configureLogger applies the configured log level and format
+func configureLogger(logger *logrus.Logger, cfg *config.Config) {
+	if cfg.LogFormat == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+
+	level, err := logrus.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		logger.WithError(err).Warn("Invalid log level, using info")
+		return
+	}
+	logger.SetLevel(level)
+}
+
+// logConfig logs the effective configuration, secrets masked
+func logConfig(logger *logrus.Logger, cfg *config.Config) {
+	fields := logrus.Fields{}
+	for _, setting := range cfg.Settings() {
+		fields[setting.Key] = setting.Value
+	}
+	if cfg.File != "" {
+		fields["config_file"] = cfg.File
+	}
+	logger.WithFields(fields).Info("Effective configuration")
+}
+
+// runBotWithRecovery runs the bot until a signal stops it, restarting it when it fails
+// Exits once it failed MaxRestarts times within CrashWindow, leaving the restart to the container
 func runBotWithRecovery(cfg *config.Config, logger *logrus.Logger) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// Reload requests go to whichever bot is running, one arriving between restarts waits for the next
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
 
+	var crashes []time.Time
 	for {
-		botChan := make(chan error, 1)
-		var discordBot *bot.Bot
-
-		// Run bot in goroutine to catch panics
-		go func() {
-			defer func() {
-				if r := recover(); r != nil {
-					logger.WithField("panic", r).
-						WithField("stack", string(debug.Stack())).
-						Error("CRITICAL: Panic caught (bug in discordgo fork) - restarting bot")
-					
-					// Clean up bot if it exists
-					if discordBot != nil {
-						func() {
-							defer func() {
-								if r := recover(); r != nil {
-									logger.WithField("panic", r).Error("Panic during bot cleanup, ignoring")
-								}
-							}()
-							_ = discordBot.Stop()
-						}()
-					}
-					
-					botChan <- fmt.Errorf("panic: %v", r)
-				}
-			}()
-
-			// Create bot
-			var err error
-			discordBot, err = bot.New(cfg, logger)
-			if err != nil {
-				logger.WithError(err).Fatal("Failed to create bot")
-			}
+		latest, err := runBot(cfg, logger, sigChan, hupChan)
+		if err == nil {
+			return
+		}
+		if latest != nil {
+			// Keep the settings reloaded while it ran
+			cfg = latest
+		}
 
-			// Start bot
-			err = discordBot.Start()
-			if err != nil {
-				logger.WithError(err).Fatal("Failed to start bot")
+		now := time.Now()
+		recent := crashes[:0]
+		for _, t := range crashes {
+			if now.Sub(t) < cfg.CrashWindow {
+				recent = append(recent, t)
 			}
+		}
+		crashes = append(recent, now)
+		if len(crashes) > cfg.MaxRestarts {
+			logger.WithError(err).Fatalf("Bot crashed %d times within %v, giving up", len(crashes), cfg.CrashWindow)
+		}
+
+		logger.Warnf("Bot crashed, waiting 5 seconds before restart: %v", err)
+		time.Sleep(5 * time.Second)
+	}
+}
 
-			botChan <- nil
-
-			// Wait for interrupt
-			<-sigChan
-			
-			// Stop gracefully
-			err = discordBot.Stop()
-			if err != nil {
-				logger.WithError(err).Error("Error stopping bot")
-			} else {
-				logger.Info("Bot stopped successfully")
+// runBot runs one instance of the bot
+// Returns nil once a signal on sigChan stopped it, otherwise why it has to be restarted and its latest configuration
+// A signal on hupChan makes it reload its configuration
+func runBot(cfg *config.Config, logger *logrus.Logger, sigChan, hupChan <-chan os.Signal) (latest *config.Config, err error) {
+	var discordBot *bot.Bot
+
+	// Catches panics while starting, e.g. from the discordgo fork
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			logger.WithField("panic", r).
+				WithField("stack", string(stack)).
+				Error("CRITICAL: Panic caught (bug in discordgo fork) - restarting bot")
+			if discordBot != nil {
+				discordBot.ReportCrash("start", r, stack)
+				latest = discordBot.Config()
+				stopBot(discordBot, logger)
 			}
-			os.Exit(0)
-		}()
-
-		// Wait for bot to start or panic
-		err := <-botChan
-		if err != nil {
-			logger.Warnf("Bot crashed, waiting 5 seconds before restart: %v", err)
-			time.Sleep(5 * time.Second)
-			continue // Restart bot
+			err = fmt.Errorf("panic: %v", r)
 		}
+	}()
 
-		// If we get here, bot started successfully
-		// Wait for interrupt signal
-		<-sigChan
-		return
+	// Create bot
+	discordBot, err = bot.New(cfg, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to create bot")
+	}
+
+	// Start bot
+	err = discordBot.Start(hupChan)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to start bot")
+	}
+
+	select {
+	case <-sigChan:
+		// Stop gracefully
+		if err := discordBot.Stop(); err != nil {
+			logger.WithError(err).Error("Error stopping bot")
+		} else {
+			logger.Info("Bot stopped successfully")
+		}
+		return nil, nil
+	case err := <-discordBot.Failed():
+		logger.WithError(err).Error("Bot failed, restarting it")
+		latest := discordBot.Config()
+		stopBot(discordBot, logger)
+		return latest, err
 	}
 }
 
+// stopBot stops a failed bot, ignoring panics of its half-broken state
+func stopBot(discordBot *bot.Bot, logger *logrus.Logger) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.WithField("panic", r).Error("Panic during bot cleanup, ignoring")
+		}
+	}()
+	_ = discordBot.Stop()
+}
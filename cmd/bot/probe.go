@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ankogit/4duk-discord-bot/internal/audio"
+	"github.com/ankogit/4duk-discord-bot/internal/config"
+)
+
+// probeConnectTimeout is how long a probe waits for the first audio
+const probeConnectTimeout = 30 * time.Second
+
+// runProbe checks a radio station without connecting to Discord
+// Returns the exit code
+func runProbe(args []string) int {
+	flags := flag.NewFlagSet("probe", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: bot probe [flags] <url>")
+		flags.PrintDefaults()
+	}
+	duration := flags.Duration("duration", 30*time.Second, "how long to listen after the first audio")
+	samplePath := flags.String("sample", "", "write the start of the audio to this WAV file")
+	sampleDuration := flags.Duration("sample-duration", 10*time.Second, "length of the WAV sample")
+	debug := flags.Bool("debug", false, "show all ffmpeg output")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	radioURL := flags.Arg(0)
+
+	cfg, err := config.LoadOffline()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	if *debug {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	opts := audio.ProbeOptions{Duration: *duration, StallThreshold: cfg.StreamStallThreshold}
+	if *samplePath != "" {
+		opts.SampleDuration = *sampleDuration
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, probeConnectTimeout+*duration)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "Probing %s for %v...\n", radioURL, *duration)
+	result, probeErr := audio.Probe(ctx, radioURL, opts, logger.WithField("station", radioURL))
+	printProbeResult(result, probeErr)
+
+	if *samplePath != "" && len(result.Sample) > 0 {
+		if err := writeSample(*samplePath, result.Sample); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("\nSample of %v written to %s\n", sampleLength(result.Sample), *samplePath)
+	}
+
+	if probeErr != nil {
+		return 1
+	}
+	return 0
+}
+
+// printProbeResult prints what a probe found out
+func printProbeResult(result *audio.ProbeResult, probeErr error) {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer table.Flush()
+
+	fmt.Fprintf(table, "station\t%s\n", result.Station)
+	if probeErr != nil {
+		fmt.Fprintf(table, "result\tFAILED: %v\n", probeErr)
+	} else {
+		fmt.Fprintf(table, "result\tok\n")
+	}
+
+	format := result.Codec
+	if result.SampleRate > 0 {
+		format += fmt.Sprintf(", %d Hz", result.SampleRate)
+	}
+	if result.BitrateKbps > 0 {
+		format += fmt.Sprintf(", %d kb/s", result.BitrateKbps)
+	}
+	fmt.Fprintf(table, "format\t%s\n", orDash(format))
+
+	names := make([]string, 0, len(result.ICY))
+	for name := range result.ICY {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(table, "%s\t%s\n", name, result.ICY[name])
+	}
+	fmt.Fprintf(table, "title\t%s\n", orDash(result.Title))
+
+	if result.Frames == 0 {
+		fmt.Fprintf(table, "first audio\t-\n")
+	} else {
+		fmt.Fprintf(table, "first audio\t%v\n", result.FirstAudio.Round(time.Millisecond))
+		fmt.Fprintf(table, "listened\t%v, %v of audio\n",
+			result.Listened.Round(time.Millisecond), time.Duration(result.Frames)*audio.FrameDuration)
+		fmt.Fprintf(table, "stalls\t%d, longest wait for audio %v\n", result.Stalls, result.LongestWait.Round(time.Millisecond))
+	}
+	if result.EncodeErrors > 0 {
+		fmt.Fprintf(table, "encode errors\t%d\n", result.EncodeErrors)
+	}
+	fmt.Fprintf(table, "last error\t%s\n", orDash(result.LastError))
+}
+
+// writeSample writes PCM as a WAV file
+func writeSample(path string, pcm []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create sample: %w", err)
+	}
+	if err := audio.WriteWAV(file, pcm); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write sample: %w", err)
+	}
+	return file.Close()
+}
+
+// sampleLength returns the length of PCM in Discord's format
+func sampleLength(pcm []byte) time.Duration {
+	return time.Duration(len(pcm)/audio.PCMFrameSize) * audio.FrameDuration
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
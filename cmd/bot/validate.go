@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+
+	"github.com/ankogit/4duk-discord-bot/internal/config"
+	"github.com/ankogit/4duk-discord-bot/internal/radio"
+)
+
+// runValidateConfig checks the configuration and the saved guild settings without starting the bot
+// No file is changed, old settings files are only migrated when the bot starts
+// Returns the exit code
+func runValidateConfig(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: bot validate-config")
+		return 2
+	}
+
+	failed := false
+	report := func(err error, format string, a ...interface{}) {
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s: %v\n", fmt.Sprintf(format, a...), err)
+			return
+		}
+		fmt.Printf("ok    %s\n", fmt.Sprintf(format, a...))
+	}
+
+	cfg, err := config.Load()
+	report(err, "configuration")
+	if err != nil {
+		return 1
+	}
+
+	_, err = exec.LookPath("ffmpeg")
+	report(err, "ffmpeg")
+
+	check, err := radio.CheckStorage(cfg.StorageBackend, cfg.DataDir)
+	switch {
+	case err != nil:
+		report(err, "guild settings")
+	case check.Path == "":
+		report(nil, "guild settings: kept in memory")
+	default:
+		report(nil, "guild settings %s: %d guilds, version %d", check.Path, check.Guilds, check.Version)
+		if check.Version < radio.SchemaVersion {
+			fmt.Printf("      will be migrated to version %d when the bot starts\n", radio.SchemaVersion)
+		}
+		for _, guildID := range check.InvalidGuilds() {
+			report(check.Invalid[guildID], "guild %s", guildID)
+		}
+	}
+
+	path, archived, err := radio.CheckArchive(cfg.StorageBackend, cfg.DataDir)
+	if path != "" {
+		report(err, "guild archive %s: %d guilds", path, archived)
+	}
+
+	fmt.Println()
+	printSettings(cfg)
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// printSettings prints the effective configuration, secrets masked
+func printSettings(cfg *config.Config) {
+	if cfg.File != "" {
+		fmt.Printf("Config file %s\n", cfg.File)
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "SETTING\tENV\tVALUE\tFROM")
+	for _, setting := range cfg.Settings() {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", setting.Key, setting.Env, orDash(setting.Value), setting.Source)
+	}
+	table.Flush()
+}
@@ -1,5 +1,7 @@
 package audio
 
+import "time"
+
 const (
 	// SampleRate is the audio sample rate required by Discord (48kHz)
 	SampleRate = 48000
@@ -9,4 +11,6 @@ const (
 	FrameSize = 960
 	// PCMFrameSize is the size of PCM frame in bytes (FrameSize * 2 bytes per sample * Channels)
 	PCMFrameSize = FrameSize * 2 * Channels // 960 * 2 * 2 = 3840 bytes
+	// FrameDuration is the length of audio in one frame
+	FrameDuration = 20 * time.Millisecond
 )
@@ -0,0 +1,260 @@
+package audio
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ankogit/4duk-discord-bot/internal/metrics"
+)
+
+// Conditions detected in ffmpeg's output
+const (
+	FFmpegHTTPError = "http_error"
+	FFmpegReconnect = "reconnect"
+	FFmpegError     = "error"
+)
+
+const (
+	// ffmpegLogInterval is how often the same ffmpeg message may be logged
+	ffmpegLogInterval = 30 * time.Second
+	// maxFFmpegMessages bounds the messages remembered for rate limiting
+	maxFFmpegMessages = 100
+	// maxFFmpegLine bounds a line of ffmpeg output kept in the buffer
+	maxFFmpegLine = 4096
+	// upstreamErrorWindow is how recent an HTTP error must be to explain ffmpeg exiting
+	upstreamErrorWindow = 30 * time.Second
+)
+
+var (
+	// "HTTP error 404 Not Found", "Server returned 403 Forbidden (access denied)", "Server returned 5XX Server Error reply"
+	httpErrorPattern = regexp.MustCompile(`(?:HTTP error|Server returned) (\d{3}|[45]XX)\b`)
+	// "Will reconnect at 1234 in 2 second(s), error=Connection refused."
+	reconnectPattern = regexp.MustCompile(`Will reconnect at \d+ in (\d+) second`)
+	// "Stream #0:0: Audio: mp3, 44100 Hz, stereo, fltp, 128 kb/s"
+	audioStreamPattern = regexp.MustCompile(`Stream #\d+:\d+.*?: Audio: (.+)`)
+	// "StreamTitle     : Artist - Song", printed with the input's metadata
+	streamTitlePattern = regexp.MustCompile(`^StreamTitle\s*:\s*(.*)$`)
+	// "icy-name        : 4duk", ICY headers of the station printed with the input's metadata
+	icyPattern = regexp.MustCompile(`^(icy-[\w-]+)\s*:\s*(.*)$`)
+	// "Duration: N/A, start: 0.000000, bitrate: 128 kb/s"
+	bitratePattern    = regexp.MustCompile(`(\d+) kb/s`)
+	sampleRatePattern = regexp.MustCompile(`(\d+) Hz`)
+	errorPattern      = regexp.MustCompile(`(?i)error|failed|refused|timed out|invalid|not found`)
+	numberPattern     = regexp.MustCompile(`\d+`)
+)
+
+// UpstreamError is returned when a stream ended after the radio station answered with an HTTP error
+type UpstreamError struct {
+	Status  string // Status code, e.g. "404", or "4XX" when ffmpeg doesn't know it
+	Message string // Line of ffmpeg output reporting the error
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("radio station returned HTTP %s", e.Status)
+}
+
+// Permanent reports whether the station refused the stream, so retrying soon is unlikely to help
+func (e *UpstreamError) Permanent() bool {
+	return strings.HasPrefix(e.Status, "4") && e.Status != "408" && e.Status != "429"
+}
+
+// ffmpegArgs returns the arguments of ffmpeg decoding a station to PCM in Discord's format
+func ffmpegArgs(radioURL string) []string {
+	return []string{
+		"-hide_banner",
+		"-nostats",
+		"-loglevel", "info",
+		"-reconnect", "1",
+		"-reconnect_streamed", "1",
+		"-reconnect_delay_max", "5",
+		"-reconnect_at_eof", "1",
+		"-i", radioURL,
+		"-f", "s16le",
+		"-ar", "48000",
+		"-ac", "2",
+		"-",
+	}
+}
+
+// ffmpegLog turns the stderr of one ffmpeg process into structured log entries
+// Repeats of a message are logged at most once per ffmpegLogInterval
+type ffmpegLog struct {
+	log     func() *logrus.Entry // Looked up per message, so debug logging can be turned on mid-stream
+	metrics *metrics.Metrics
+	guildID string
+	tracker *streamTracker
+
+	mu       sync.Mutex
+	buf      []byte
+	lastHTTP *UpstreamError
+	httpAt   time.Time
+	seen     map[string]*ffmpegMessage
+}
+
+// ffmpegMessage tracks when a message was last logged
+type ffmpegMessage struct {
+	logged     time.Time
+	suppressed int
+}
+
+func newFFmpegLog(log func() *logrus.Entry, metrics *metrics.Metrics, guildID string, tracker *streamTracker) *ffmpegLog {
+	return &ffmpegLog{
+		log:     log,
+		metrics: metrics,
+		guildID: guildID,
+		tracker: tracker,
+		seen:    make(map[string]*ffmpegMessage),
+	}
+}
+
+// Write buffers ffmpeg output and handles every complete line
+func (f *ffmpegLog) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.buf = append(f.buf, p...)
+	for {
+		i := bytes.IndexAny(f.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(f.buf[:i]))
+		f.buf = f.buf[i+1:]
+		if line != "" {
+			f.handle(line)
+		}
+	}
+	if len(f.buf) > maxFFmpegLine {
+		f.handle(strings.TrimSpace(string(f.buf)))
+		f.buf = f.buf[:0]
+	}
+	return len(p), nil
+}
+
+// upstreamError returns the HTTP error reported by ffmpeg shortly before, if any
+// Older errors were followed by a successful reconnect
+func (f *ffmpegLog) upstreamError() *UpstreamError {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lastHTTP == nil || time.Since(f.httpAt) > upstreamErrorWindow {
+		return nil
+	}
+	return f.lastHTTP
+}
+
+// handle classifies a line of ffmpeg output
+// Must be called with mu held
+func (f *ffmpegLog) handle(line string) {
+	if m := httpErrorPattern.FindStringSubmatch(line); m != nil {
+		f.lastHTTP = &UpstreamError{Status: m[1], Message: line}
+		f.httpAt = time.Now()
+		f.metrics.FFmpegEvent(f.guildID, FFmpegHTTPError)
+		f.tracker.update(func(status *StreamStatus) { status.LastError = line })
+		f.emit(logrus.WarnLevel, line, logrus.Fields{"status": m[1], "ffmpeg": line}, "Radio station returned an HTTP error")
+		return
+	}
+
+	if m := reconnectPattern.FindStringSubmatch(line); m != nil {
+		delay, _ := strconv.Atoi(m[1])
+		f.metrics.FFmpegEvent(f.guildID, FFmpegReconnect)
+		f.emit(logrus.WarnLevel, line, logrus.Fields{"delay_seconds": delay, "ffmpeg": line}, "ffmpeg is reconnecting to the radio station")
+		return
+	}
+
+	if m := streamTitlePattern.FindStringSubmatch(line); m != nil {
+		title := strings.TrimSpace(m[1])
+		f.tracker.update(func(status *StreamStatus) { status.Title = title })
+		f.emit(logrus.InfoLevel, line, logrus.Fields{"title": title}, "Now playing")
+		return
+	}
+
+	if m := icyPattern.FindStringSubmatch(line); m != nil {
+		name, value := strings.ToLower(m[1]), strings.TrimSpace(m[2])
+		f.tracker.update(func(status *StreamStatus) {
+			if status.ICY == nil {
+				status.ICY = make(map[string]string)
+			}
+			status.ICY[name] = value
+		})
+		f.emit(logrus.DebugLevel, line, logrus.Fields{"header": name, "value": value}, "Radio station ICY header")
+		return
+	}
+
+	if m := audioStreamPattern.FindStringSubmatch(line); m != nil {
+		codec := strings.TrimSpace(strings.SplitN(m[1], ",", 2)[0])
+		fields := logrus.Fields{"codec": codec, "format": m[1]}
+		kbps, sampleRate := 0, 0
+		if b := bitratePattern.FindStringSubmatch(m[1]); b != nil {
+			kbps, _ = strconv.Atoi(b[1])
+			fields["bitrate_kbps"] = kbps
+		}
+		if r := sampleRatePattern.FindStringSubmatch(m[1]); r != nil {
+			sampleRate, _ = strconv.Atoi(r[1])
+			fields["sample_rate"] = sampleRate
+		}
+		f.tracker.update(func(status *StreamStatus) {
+			status.Codec = codec
+			if kbps > 0 {
+				status.BitrateKbps = kbps
+			}
+			if sampleRate > 0 {
+				status.SampleRate = sampleRate
+			}
+		})
+		f.emit(logrus.InfoLevel, line, fields, "Radio stream format")
+		return
+	}
+
+	if strings.HasPrefix(line, "Duration:") {
+		if b := bitratePattern.FindStringSubmatch(line); b != nil {
+			kbps, _ := strconv.Atoi(b[1])
+			f.tracker.update(func(status *StreamStatus) { status.BitrateKbps = kbps })
+			f.emit(logrus.DebugLevel, line, logrus.Fields{"bitrate_kbps": kbps}, "Radio stream bitrate")
+		}
+		return
+	}
+
+	if errorPattern.MatchString(line) {
+		f.metrics.FFmpegEvent(f.guildID, FFmpegError)
+		f.tracker.update(func(status *StreamStatus) { status.LastError = line })
+		f.emit(logrus.WarnLevel, line, logrus.Fields{"ffmpeg": line}, "ffmpeg reported an error")
+		return
+	}
+
+	f.emit(logrus.DebugLevel, line, logrus.Fields{"ffmpeg": line}, "ffmpeg output")
+}
+
+// emit logs a message unless the same line was logged within ffmpegLogInterval
+// Lines differing only in numbers, e.g. byte offsets, count as the same
+func (f *ffmpegLog) emit(level logrus.Level, line string, fields logrus.Fields, msg string) {
+	key := numberPattern.ReplaceAllString(line, "#")
+	now := time.Now()
+
+	seen, exists := f.seen[key]
+	if exists && now.Sub(seen.logged) < ffmpegLogInterval {
+		seen.suppressed++
+		return
+	}
+	if !exists {
+		if len(f.seen) >= maxFFmpegMessages {
+			f.seen = make(map[string]*ffmpegMessage)
+		}
+		seen = &ffmpegMessage{}
+		f.seen[key] = seen
+	}
+
+	entry := f.log().WithFields(fields)
+	if seen.suppressed > 0 {
+		entry = entry.WithField("repeated", seen.suppressed)
+	}
+	seen.logged = now
+	seen.suppressed = 0
+	entry.Log(level, msg)
+}
@@ -0,0 +1,160 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/hraban/opus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ankogit/4duk-discord-bot/internal/metrics"
+)
+
+// probeGuildID stands in for a guild in the metrics and logs of a probe
+const probeGuildID = "probe"
+
+// ProbeOptions controls a probe of a radio station
+type ProbeOptions struct {
+	Duration       time.Duration // How long to listen after the first audio
+	SampleDuration time.Duration // How much audio to keep as a sample, none if zero
+	StallThreshold time.Duration // How long a read of one frame may take before the station counts as stalled
+}
+
+// ProbeResult describes a radio station as the streamer sees it
+type ProbeResult struct {
+	StreamStatus
+	FirstAudio   time.Duration // From starting ffmpeg to the first frame
+	Listened     time.Duration // From the first frame to the end of the probe
+	Frames       int
+	LongestWait  time.Duration // Longest wait for a frame after the first
+	EncodeErrors int
+	Sample       []byte // PCM in Discord's format, see WriteWAV
+}
+
+// Probe decodes a station the way Stream does, without sending the audio anywhere
+// The partial result is returned along with an error if the stream failed
+func Probe(ctx context.Context, radioURL string, opts ProbeOptions, log *logrus.Entry) (*ProbeResult, error) {
+	tracker := newStreamTracker(radioURL, opts.StallThreshold)
+	startedAt := tracker.status.StartedAt
+	result := &ProbeResult{}
+	defer func() { result.StreamStatus = tracker.snapshot() }()
+
+	encoder, err := opus.NewEncoder(SampleRate, Channels, opus.AppAudio)
+	if err != nil {
+		return result, fmt.Errorf("failed to create opus encoder: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs(radioURL)...)
+	stderr := newFFmpegLog(func() *logrus.Entry { return log }, metrics.New("none"), probeGuildID, tracker)
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return result, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return result, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			cancel()
+			cmd.Wait()
+		})
+	}
+	defer stop()
+
+	sampleSize := int(opts.SampleDuration/FrameDuration) * PCMFrameSize
+	pcmBytes := make([]byte, PCMFrameSize)
+	pcm := make([]int16, FrameSize*Channels)
+	opusFrame := make([]byte, 4000)
+	var firstAt time.Time
+
+	for firstAt.IsZero() || time.Since(firstAt) < opts.Duration {
+		readStart := time.Now()
+		if _, err := io.ReadFull(stdout, pcmBytes); err != nil {
+			timedOut := ctx.Err() != nil
+			stop()
+			if !firstAt.IsZero() {
+				result.Listened = time.Since(firstAt)
+			}
+			switch upstreamErr := stderr.upstreamError(); {
+			case upstreamErr != nil:
+				return result, fmt.Errorf("stream ended: %w", upstreamErr)
+			case timedOut && firstAt.IsZero():
+				return result, fmt.Errorf("no audio after %v", time.Since(startedAt).Round(time.Millisecond))
+			case timedOut:
+				return result, fmt.Errorf("probe cancelled: %w", context.Cause(ctx))
+			}
+			return result, fmt.Errorf("stream ended: %w", err)
+		}
+
+		now := time.Now()
+		tracker.frameRead(now)
+		result.Frames++
+
+		if firstAt.IsZero() {
+			firstAt = now
+			result.FirstAudio = now.Sub(startedAt)
+		} else {
+			elapsed := now.Sub(readStart)
+			if elapsed > result.LongestWait {
+				result.LongestWait = elapsed
+			}
+			if elapsed > opts.StallThreshold {
+				tracker.update(func(status *StreamStatus) { status.Stalls++ })
+				log.Warnf("Radio station stalled for %v", elapsed.Round(time.Millisecond))
+			}
+		}
+
+		if len(result.Sample) < sampleSize {
+			result.Sample = append(result.Sample, pcmBytes...)
+		}
+
+		for i := 0; i < len(pcm); i++ {
+			pcm[i] = int16(binary.LittleEndian.Uint16(pcmBytes[i*2:]))
+		}
+		if _, err := encoder.Encode(pcm, opusFrame); err != nil {
+			result.EncodeErrors++
+		}
+	}
+
+	result.Listened = time.Since(firstAt)
+	return result, nil
+}
+
+// WriteWAV writes PCM in Discord's format as a WAV file
+func WriteWAV(w io.Writer, pcm []byte) error {
+	const bitsPerSample = 16
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(36 + len(pcm)),
+		[4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16), // Size of the fmt chunk
+		uint16(1),  // PCM
+		uint16(Channels),
+		uint32(SampleRate),
+		uint32(SampleRate * Channels * bitsPerSample / 8), // Bytes per second
+		uint16(Channels * bitsPerSample / 8),              // Bytes per frame
+		uint16(bitsPerSample),
+		[4]byte{'d', 'a', 't', 'a'},
+		uint32(len(pcm)),
+	}
+	for _, field := range header {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(pcm)
+	return err
+}
@@ -0,0 +1,107 @@
+package audio
+
+import (
+	"sync"
+	"time"
+)
+
+// Stream health
+const (
+	StreamConnecting = "connecting" // ffmpeg hasn't delivered audio yet
+	StreamOK         = "ok"
+	StreamStalled    = "stalled" // No audio for longer than the stall threshold
+)
+
+// StreamStatus describes a running stream
+type StreamStatus struct {
+	Station     string            `json:"station"`
+	Title       string            `json:"title,omitempty"` // Now playing, if the station sends it
+	Codec       string            `json:"codec,omitempty"`
+	BitrateKbps int               `json:"bitrate_kbps,omitempty"`
+	SampleRate  int               `json:"sample_rate,omitempty"` // Of the station, before resampling for Discord
+	ICY         map[string]string `json:"icy,omitempty"`         // ICY headers of the station, e.g. icy-name
+	StartedAt   time.Time         `json:"started_at"`
+	LastFrameAt time.Time         `json:"last_frame_at"`
+	Stalls      int               `json:"stalls"`
+	LastError   string            `json:"last_error,omitempty"` // Last error reported by ffmpeg
+	Health      string            `json:"health"`
+}
+
+// streamTracker holds the status of one stream
+type streamTracker struct {
+	mu             sync.Mutex
+	status         StreamStatus
+	stallThreshold time.Duration
+}
+
+func newStreamTracker(station string, stallThreshold time.Duration) *streamTracker {
+	return &streamTracker{
+		status:         StreamStatus{Station: station, StartedAt: time.Now()},
+		stallThreshold: stallThreshold,
+	}
+}
+
+// update changes the status
+func (t *streamTracker) update(change func(status *StreamStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change(&t.status)
+}
+
+// frameRead records that audio arrived
+func (t *streamTracker) frameRead(at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.LastFrameAt = at
+}
+
+// snapshot returns a copy of the status with its health
+func (t *streamTracker) snapshot() StreamStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.status
+	if t.status.ICY != nil {
+		status.ICY = make(map[string]string, len(t.status.ICY))
+		for name, value := range t.status.ICY {
+			status.ICY[name] = value
+		}
+	}
+	switch {
+	case status.LastFrameAt.IsZero():
+		status.Health = StreamConnecting
+	case time.Since(status.LastFrameAt) > t.stallThreshold:
+		status.Health = StreamStalled
+	default:
+		status.Health = StreamOK
+	}
+	return status
+}
+
+// track starts tracking the stream of a guild, replacing a previous one
+func (s *Streamer) track(guildID string, cfg StreamerConfig) *streamTracker {
+	tracker := newStreamTracker(cfg.RadioURL, cfg.StallThreshold)
+	s.mu.Lock()
+	s.streams[guildID] = tracker
+	s.mu.Unlock()
+	return tracker
+}
+
+// untrack stops tracking a stream unless a newer stream replaced it
+func (s *Streamer) untrack(guildID string, tracker *streamTracker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams[guildID] == tracker {
+		delete(s.streams, guildID)
+	}
+}
+
+// Status returns the status of the stream playing in a guild
+func (s *Streamer) Status(guildID string) (StreamStatus, bool) {
+	s.mu.Lock()
+	tracker, exists := s.streams[guildID]
+	s.mu.Unlock()
+	if !exists {
+		return StreamStatus{}, false
+	}
+	return tracker.snapshot(), true
+}
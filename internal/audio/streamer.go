@@ -5,37 +5,86 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
+	"math"
 	"os/exec"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/sirupsen/logrus"
+
+	"github.com/ankogit/4duk-discord-bot/internal/config"
+	"github.com/ankogit/4duk-discord-bot/internal/metrics"
 )
 
+// StreamerConfig holds the settings of the streamer
+type StreamerConfig struct {
+	RadioURL       string
+	StabilizeDelay time.Duration // Wait before the first frame is sent
+	SendTimeout    time.Duration // How long sending one frame to Discord may take
+	StallThreshold time.Duration // How long a read of one frame may take before the station counts as stalled
+}
+
+// StreamOptions describe what a stream of a guild plays
+type StreamOptions struct {
+	Station  string      // Station URL, RadioURL if empty
+	IsActive func() bool // The stream ends once it reports false
+	IsMuted  func() bool // Frames are dropped while it reports true
+	Volume   func() int  // Volume in percent, read for every frame
+}
+
 // Streamer handles audio streaming to Discord
 type Streamer struct {
-	radioURL    string
+	config      StreamerConfig // Guarded by mu, see SetConfig
 	encoderPool *EncoderPool
-	logger      *logrus.Logger
+	metrics     *metrics.Metrics
+	log         func(guildID string) *logrus.Entry
+
+	streams map[string]*streamTracker // Running streams by guild ID
+	mu      sync.Mutex
 }
 
 // NewStreamer creates a new audio streamer
-func NewStreamer(radioURL string, encoderPool *EncoderPool, logger *logrus.Logger) *Streamer {
+// log returns the logger for messages about a guild
+func NewStreamer(config StreamerConfig, encoderPool *EncoderPool, metrics *metrics.Metrics, log func(guildID string) *logrus.Entry) *Streamer {
 	return &Streamer{
-		radioURL:    radioURL,
+		config:      config,
 		encoderPool: encoderPool,
-		logger:      logger,
+		metrics:     metrics,
+		log:         log,
+		streams:     make(map[string]*streamTracker),
 	}
 }
 
-// Stream streams audio from URL to Discord voice connection
-func (s *Streamer) Stream(ctx context.Context, vc *discordgo.VoiceConnection, guildID string, isActive func() bool) error {
-	s.logger.Infof("[%s] Starting radio stream: %s", guildID, s.radioURL)
+// SetConfig changes the settings of streams started from now on
+func (s *Streamer) SetConfig(config StreamerConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+}
+
+// Stream streams audio from a station to Discord voice connection
+// While muted the stream keeps running but frames are dropped
+func (s *Streamer) Stream(ctx context.Context, vc *discordgo.VoiceConnection, guildID string, opts StreamOptions) error {
+	s.mu.Lock()
+	cfg := s.config
+	s.mu.Unlock()
+	if opts.Station != "" {
+		cfg.RadioURL = opts.Station
+	}
+	isActive, isMuted := opts.IsActive, opts.IsMuted
+
+	// Logged without credentials, see config.RedactURL
+	station := config.RedactURL(cfg.RadioURL)
+	log := s.log(guildID).WithField("station", station)
+	log.Info("Starting radio stream")
+
+	tracker := s.track(guildID, cfg)
+	defer s.untrack(guildID, tracker)
 
 	// Wait a bit for voice connection to stabilize
 	select {
-	case <-time.After(1 * time.Second):
+	case <-time.After(cfg.StabilizeDelay):
 	case <-ctx.Done():
 		return ctx.Err()
 	}
@@ -60,20 +109,11 @@ func (s *Streamer) Stream(ctx context.Context, vc *discordgo.VoiceConnection, gu
 
 	// FFmpeg command to stream audio and convert to PCM
 	// We'll encode PCM to Opus using the opus library
-	ffmpegArgs := []string{
-		"-reconnect", "1",
-		"-reconnect_streamed", "1",
-		"-reconnect_delay_max", "5",
-		"-reconnect_at_eof", "1",
-		"-i", s.radioURL,
-		"-f", "s16le",
-		"-ar", "48000",
-		"-ac", "2",
-		"-",
-	}
-
-	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs...)
-	cmd.Stderr = os.Stderr // Log ffmpeg errors
+	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs(cfg.RadioURL)...)
+	stderr := newFFmpegLog(func() *logrus.Entry {
+		return s.log(guildID).WithField("station", station)
+	}, s.metrics, guildID, tracker)
+	cmd.Stderr = stderr
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -84,16 +124,25 @@ func (s *Streamer) Stream(ctx context.Context, vc *discordgo.VoiceConnection, gu
 		return fmt.Errorf("failed to start ffmpeg: %w", err)
 	}
 
-	defer func() {
-		if cmd.Process != nil {
-			cmd.Process.Kill()
-		}
-		cmd.Wait()
-	}()
+	// Stop ffmpeg and wait until all of its output is handled
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			if cmd.Process != nil {
+				cmd.Process.Kill()
+			}
+			cmd.Wait()
+		})
+	}
+	defer stop()
+
+	defer s.metrics.StreamStarted(cfg.RadioURL)()
 
 	// Buffer for reading PCM data
 	buffer := make([]int16, FrameSize*Channels)
 	pcmBytes := make([]byte, PCMFrameSize)
+	speaking := true
+	firstFrame := true
 
 	// Send audio in a loop
 	for {
@@ -114,28 +163,63 @@ func (s *Streamer) Stream(ctx context.Context, vc *discordgo.VoiceConnection, gu
 		}
 
 		// Read PCM data (s16le format from ffmpeg)
+		readStart := time.Now()
 		_, err := io.ReadFull(stdout, pcmBytes)
 		if err != nil {
+			// ffmpeg died under us rather than being stopped
+			if ctx.Err() == nil && isActive() {
+				s.metrics.FFmpegRestart(guildID)
+				stop()
+				if upstreamErr := stderr.upstreamError(); upstreamErr != nil {
+					return fmt.Errorf("stream ended: %w", upstreamErr)
+				}
+			}
 			if err == io.EOF || err == io.ErrUnexpectedEOF {
 				return fmt.Errorf("stream ended: %w", err)
 			}
 			return fmt.Errorf("error reading audio data: %w", err)
 		}
 
+		tracker.frameRead(time.Now())
+
+		// The first frame waits for ffmpeg to connect to the station
+		if elapsed := time.Since(readStart); !firstFrame && elapsed > cfg.StallThreshold {
+			s.metrics.UpstreamStall(guildID)
+			tracker.update(func(status *StreamStatus) { status.Stalls++ })
+			log.Warnf("Radio station stalled for %v", elapsed.Round(time.Millisecond))
+		}
+		firstFrame = false
+
+		// Keep ffmpeg running but stay silent while muted
+		if isMuted() {
+			if speaking {
+				vc.Speaking(false)
+				speaking = false
+			}
+			continue
+		}
+		if !speaking {
+			if err := vc.Speaking(true); err != nil {
+				return fmt.Errorf("failed to set speaking: %w", err)
+			}
+			speaking = true
+		}
+
 		// Convert bytes to int16 samples (little-endian)
 		for i := 0; i < len(buffer); i++ {
 			buffer[i] = int16(binary.LittleEndian.Uint16(pcmBytes[i*2:]))
 		}
+		applyVolume(buffer, opts.Volume())
 
 		// Encode to Opus and send
-		if err := s.sendFrame(vc, guildID, buffer); err != nil {
+		if err := s.sendFrame(vc, guildID, buffer, cfg.SendTimeout); err != nil {
 			return fmt.Errorf("error sending audio frame: %w", err)
 		}
 	}
 }
 
 // sendFrame sends a PCM frame to Discord voice connection
-func (s *Streamer) sendFrame(vc *discordgo.VoiceConnection, guildID string, pcm []int16) error {
+func (s *Streamer) sendFrame(vc *discordgo.VoiceConnection, guildID string, pcm []int16, timeout time.Duration) error {
 	if vc == nil || vc.Status != discordgo.VoiceConnectionStatusReady {
 		return fmt.Errorf("voice connection not ready")
 	}
@@ -150,14 +234,34 @@ func (s *Streamer) sendFrame(vc *discordgo.VoiceConnection, guildID string, pcm
 	opusFrame := make([]byte, 4000) // Opus frame buffer (max size ~4000 bytes)
 	n, err := encoder.Encode(pcm, opusFrame)
 	if err != nil {
+		s.metrics.EncodeError(guildID)
 		return fmt.Errorf("failed to encode opus: %w", err)
 	}
 
 	// Send Opus frame
 	select {
 	case vc.OpusSend <- opusFrame[:n]:
+		s.metrics.FrameSent(guildID)
 		return nil
-	case <-time.After(100 * time.Millisecond):
+	case <-time.After(timeout):
+		s.metrics.SendTimeout(guildID)
 		return fmt.Errorf("timeout sending opus frame")
 	}
 }
+
+// applyVolume scales PCM samples by a volume in percent, clipping at the sample range
+func applyVolume(pcm []int16, percent int) {
+	if percent == 100 {
+		return
+	}
+	for i, sample := range pcm {
+		scaled := int32(sample) * int32(percent) / 100
+		switch {
+		case scaled > math.MaxInt16:
+			scaled = math.MaxInt16
+		case scaled < math.MinInt16:
+			scaled = math.MinInt16
+		}
+		pcm[i] = int16(scaled)
+	}
+}
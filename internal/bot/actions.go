@@ -0,0 +1,226 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/ankogit/4duk-discord-bot/internal/config"
+	"github.com/ankogit/4duk-discord-bot/internal/radio"
+)
+
+// Actions shared by the chat commands and the admin API
+
+// errNotSaved is returned when a setting was applied but couldn't be persisted
+var errNotSaved = errors.New("setting applied but not saved")
+
+// notSavedMessage tells users that a setting will be lost on restart
+const notSavedMessage = "⚠️ Настройка применена, но не сохранилась и пропадёт после перезапуска бота."
+
+// autoChannelRules maps the rule names accepted by commands to auto-channel rules
+var autoChannelRules = map[string]string{
+	"most":     radio.RuleMostListeners,
+	"priority": radio.RulePriority,
+	"first":    radio.RuleFirstOccupied,
+}
+
+// commandError is a rejected action with a user-facing reason
+type commandError struct {
+	Reason string
+}
+
+func (e *commandError) Error() string {
+	return e.Reason
+}
+
+// actionFailureMessage formats a failed action for users
+func actionFailureMessage(err error, fallback string) string {
+	var cmdErr *commandError
+	switch {
+	case errors.As(err, &cmdErr):
+		return cmdErr.Reason
+	case errors.Is(err, errNotSaved):
+		return notSavedMessage
+	default:
+		return joinFailureMessage(err, fallback)
+	}
+}
+
+// playInChannel starts the radio in a voice channel
+// A new request is a new session, even if the radio was already playing elsewhere
+func (b *Bot) playInChannel(guildID, channelID, startedBy string) error {
+	// Fail with a specific reason before attempting the join
+	if err := b.checkVoiceJoin(guildID, channelID); err != nil {
+		return err
+	}
+
+	b.endSession(guildID)
+	return b.connectAndPlay(guildID, channelID, startedBy)
+}
+
+// stopRadio stops the radio and leaves voice
+// Returns false if the bot wasn't in a voice channel
+func (b *Bot) stopRadio(guildID string) bool {
+	_, connected := b.session.VoiceConnections[guildID]
+	b.leaveVoice(guildID)
+	return connected
+}
+
+// reconnectGuild drops the stream of a guild and connects to its channel again
+func (b *Bot) reconnectGuild(guildID string) error {
+	state, exists := b.radioManager.Get(guildID)
+	if !exists || !state.IsActive() {
+		return &commandError{Reason: "Радио сейчас не играет."}
+	}
+
+	b.log(guildID).Info("Reconnect requested")
+	state.StopStream()
+	state.ResetReconnectAttempts()
+
+	b.goGuild(guildID, "reconnect", func() { b.reconnectRadio(guildID) })
+	return nil
+}
+
+// setAutoChannel makes a channel the only auto-connect channel and enables auto-connect
+func (b *Bot) setAutoChannel(guildID, channelID string) (*discordgo.Channel, error) {
+	channel, err := b.autoChannelCandidate(guildID, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	state := b.radioManager.GetOrCreate(guildID)
+	state.SetAutoChannels([]radio.AutoChannel{{ChannelID: channelID}})
+	state.SetAutoConnectEnabled(true)
+	b.log(guildID).Infof("Auto-channel set to %s (%s)", channel.Name, channelID)
+	if err := b.saveState(guildID); err != nil {
+		return channel, errNotSaved
+	}
+	return channel, nil
+}
+
+// addAutoChannel adds a channel to the auto-connect channels
+func (b *Bot) addAutoChannel(guildID, channelID string, priority int) (*discordgo.Channel, error) {
+	channel, err := b.autoChannelCandidate(guildID, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	b.radioManager.GetOrCreate(guildID).AddAutoChannel(channelID, priority)
+	b.log(guildID).Infof("Auto-channel %s (%s) added with priority %d", channel.Name, channelID, priority)
+	if err := b.saveState(guildID); err != nil {
+		return channel, errNotSaved
+	}
+	return channel, nil
+}
+
+// removeAutoChannel removes a channel from the auto-connect channels
+func (b *Bot) removeAutoChannel(guildID, channelID string) error {
+	if !b.radioManager.GetOrCreate(guildID).RemoveAutoChannel(channelID) {
+		return &commandError{Reason: "Этого канала нет в списке авто-подключения."}
+	}
+
+	b.log(guildID).Infof("Auto-channel %s removed", channelID)
+	if err := b.saveState(guildID); err != nil {
+		return errNotSaved
+	}
+	return nil
+}
+
+// setAutoChannelRule sets how to pick among occupied auto-channels
+// name is one of the keys of autoChannelRules
+func (b *Bot) setAutoChannelRule(guildID, name string) (string, error) {
+	rule, exists := autoChannelRules[strings.ToLower(name)]
+	if !exists {
+		return "", &commandError{Reason: "Правило должно быть одним из: most, priority, first."}
+	}
+
+	b.radioManager.GetOrCreate(guildID).SetAutoChannelRule(rule)
+	if err := b.saveState(guildID); err != nil {
+		return rule, errNotSaved
+	}
+	return rule, nil
+}
+
+// setAutoConnect enables or disables auto-connect
+func (b *Bot) setAutoConnect(guildID string, enabled bool) error {
+	b.radioManager.GetOrCreate(guildID).SetAutoConnectEnabled(enabled)
+	if err := b.saveState(guildID); err != nil {
+		return errNotSaved
+	}
+	if enabled {
+		// Skips channels that became unusable while disabled
+		b.validateAutoChannels(guildID)
+	}
+	return nil
+}
+
+// setStation sets the station of a guild, empty for RADIO_URL
+// A playing stream switches to it right away
+func (b *Bot) setStation(guildID, station string) error {
+	if station != "" {
+		if err := radio.ValidateStation(station); err != nil {
+			return &commandError{Reason: "Станция должна быть ссылкой на поток вида `http://...` или `https://...`."}
+		}
+	}
+
+	previous := b.station(guildID)
+	b.radioManager.GetOrCreate(guildID).SetStation(station)
+	b.log(guildID).WithField("station", config.RedactURL(b.station(guildID))).Info("Station set")
+	if b.station(guildID) != previous {
+		b.switchStation(guildID)
+	}
+	if err := b.saveState(guildID); err != nil {
+		return errNotSaved
+	}
+	return nil
+}
+
+// setVolume sets the volume of a guild in percent, -1 for the default
+// The stream picks it up without restarting
+func (b *Bot) setVolume(guildID string, percent int) error {
+	if percent >= 0 {
+		if err := radio.ValidateVolume(percent); err != nil {
+			return &commandError{Reason: fmt.Sprintf("Громкость должна быть от 0 до %d%%.", radio.MaxVolume)}
+		}
+	}
+
+	state := b.radioManager.GetOrCreate(guildID)
+	state.SetVolume(percent)
+	b.log(guildID).Infof("Volume set to %d%%", state.GetVolume())
+	if err := b.saveState(guildID); err != nil {
+		return errNotSaved
+	}
+	return nil
+}
+
+// autoChannelCandidate verifies a channel can be used for auto-connect
+func (b *Bot) autoChannelCandidate(guildID, channelID string) (*discordgo.Channel, error) {
+	// Get channel info to verify it exists and is a voice channel
+	channel, err := b.session.Channel(channelID)
+	if err != nil || channel.GuildID != guildID {
+		if err != nil {
+			b.log(guildID).WithError(err).Debug("Failed to get channel info")
+		}
+		return nil, &commandError{Reason: "Канал не найден. Проверьте ID канала."}
+	}
+
+	if channel.Type != discordgo.ChannelTypeGuildVoice {
+		return nil, &commandError{Reason: "Это не голосовой канал!"}
+	}
+
+	if b.isAFKChannel(guildID, channelID) {
+		return nil, &commandError{Reason: "Это AFK-канал сервера, авто-подключение к нему не поддерживается."}
+	}
+
+	// Refuse channels the bot can't use, otherwise auto-connect would fail on every join
+	missing, err := b.missingVoicePermissions(channelID)
+	if err != nil {
+		b.log(guildID).WithError(err).Debugf("Failed to compute permissions for channel %s", channelID)
+	} else if len(missing) > 0 {
+		return nil, &commandError{Reason: fmt.Sprintf("У бота нет прав **%s** в канале **%s**.", strings.Join(missing, ", "), channel.Name)}
+	}
+
+	return channel, nil
+}
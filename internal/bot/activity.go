@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxActivityEvents is how many recent events are kept per guild
+	maxActivityEvents = 50
+	// activityBuffer is how many events a slow subscriber may fall behind before missing some
+	activityBuffer = 64
+)
+
+// activityEvent is a notable thing that happened in a guild
+type activityEvent struct {
+	Time    time.Time `json:"time"`
+	GuildID string    `json:"guild_id"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// activityLog keeps the recent events of every guild and passes new ones to subscribers
+// It is a logrus hook: every message logged for a guild at info level or above is an event,
+// whatever the log level, see minHookLevel
+type activityLog struct {
+	mu          sync.Mutex
+	events      map[string][]activityEvent // By guild ID
+	subscribers map[chan activityEvent]struct{}
+}
+
+func newActivityLog() *activityLog {
+	return &activityLog{
+		events:      make(map[string][]activityEvent),
+		subscribers: make(map[chan activityEvent]struct{}),
+	}
+}
+
+// Levels returns the log levels recorded as events
+func (a *activityLog) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel, logrus.InfoLevel}
+}
+
+// Fire records a log entry about a guild
+func (a *activityLog) Fire(entry *logrus.Entry) error {
+	guildID, ok := entry.Data["guild"].(string)
+	if !ok || guildID == "" {
+		return nil
+	}
+
+	event := activityEvent{
+		Time:    entry.Time,
+		GuildID: guildID,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	events := append(a.events[guildID], event)
+	if len(events) > maxActivityEvents {
+		events = events[len(events)-maxActivityEvents:]
+	}
+	a.events[guildID] = events
+
+	for ch := range a.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Don't block logging on a slow subscriber
+		}
+	}
+	return nil
+}
+
+// Recent returns the recent events of a guild, oldest first
+func (a *activityLog) Recent(guildID string) []activityEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]activityEvent(nil), a.events[guildID]...)
+}
+
+// All returns the recent events of every guild, by guild ID
+func (a *activityLog) All() map[string][]activityEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	all := make(map[string][]activityEvent, len(a.events))
+	for guildID, events := range a.events {
+		all[guildID] = append([]activityEvent(nil), events...)
+	}
+	return all
+}
+
+// Subscribe returns a channel receiving new events until unsubscribe is called
+func (a *activityLog) Subscribe() (events <-chan activityEvent, unsubscribe func()) {
+	ch := make(chan activityEvent, activityBuffer)
+
+	a.mu.Lock()
+	a.subscribers[ch] = struct{}{}
+	a.mu.Unlock()
+
+	return ch, func() {
+		a.mu.Lock()
+		delete(a.subscribers, ch)
+		a.mu.Unlock()
+	}
+}
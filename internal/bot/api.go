@@ -0,0 +1,368 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ankogit/4duk-discord-bot/internal/audio"
+	"github.com/ankogit/4duk-discord-bot/internal/config"
+	"github.com/ankogit/4duk-discord-bot/internal/radio"
+)
+
+// maxAPIRequestSize limits the body of an admin API request
+const maxAPIRequestSize = 64 << 10
+
+// apiGuild is a guild as shown by the admin API
+type apiGuild struct {
+	ID                 string              `json:"id"`
+	Name               string              `json:"name,omitempty"`
+	Active             bool                `json:"active"`
+	ChannelID          string              `json:"channel_id,omitempty"`
+	ChannelName        string              `json:"channel_name,omitempty"`
+	Listeners          int                 `json:"listeners"`
+	Station            string              `json:"station"` // Redacted, see config.RedactURL
+	Volume             int                 `json:"volume"`  // Percent
+	Idle               bool                `json:"idle"`
+	Muted              bool                `json:"muted"`
+	FollowUserID       string              `json:"follow_user_id,omitempty"`
+	FailingSince       *time.Time          `json:"failing_since,omitempty"`
+	AutoConnectEnabled bool                `json:"auto_connect_enabled"`
+	Session            *radio.Session      `json:"session,omitempty"`
+	Stream             *audio.StreamStatus `json:"stream,omitempty"`
+	Config             *radio.GuildConfig  `json:"config,omitempty"` // Only when a single guild is requested
+	Events             []activityEvent     `json:"events,omitempty"` // Recent activity, newest last
+}
+
+// apiError is the body of a failed admin API request
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// startAdminServer starts the admin API listener if a token is configured
+func (b *Bot) startAdminServer() {
+	if b.cfg().AdminToken == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", b.handleDashboard)
+	mux.HandleFunc("/api/login", b.handleAPILogin)
+	mux.HandleFunc("/api/events", b.handleAPIEvents)
+	mux.HandleFunc("/api/guilds", b.handleAPIGuilds)
+	mux.HandleFunc("/api/guilds/", b.handleAPIGuild)
+	mux.HandleFunc("/api/crashes", b.handleAPICrashes)
+	mux.HandleFunc("/api/crashes/", b.handleAPICrash)
+
+	b.adminServer = &http.Server{
+		Addr:              b.cfg().AdminAddr,
+		Handler:           b.requireAdminToken(mux),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.logger.Infof("Admin API listener on %s", b.cfg().AdminAddr)
+		if err := b.adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			b.logger.WithError(err).Error("Admin API listener failed")
+		}
+	}()
+}
+
+// requireAdminToken rejects requests without the admin token
+// The token is sent as a bearer token, or by the dashboard in a cookie
+// The dashboard page and the login are open, they hold no data
+func (b *Bot) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" || r.URL.Path == "/api/login" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := adminRequestToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(b.cfg().AdminToken)) != 1 {
+			b.logger.WithField("remote", r.RemoteAddr).Warnf("Unauthorized admin API request %s %s", r.Method, r.URL.Path)
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminRequestToken returns the token a request was sent with
+// An Authorization header other than a bearer token is rejected, not ignored
+func adminRequestToken(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		return strings.CutPrefix(header, "Bearer ")
+	}
+	if cookie, err := r.Cookie(adminCookie); err == nil {
+		return cookie.Value, true
+	}
+	return "", false
+}
+
+// handleAPIGuilds lists the guilds the bot is in
+// GET /api/guilds
+func (b *Bot) handleAPIGuilds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	guildIDs := b.botGuildIDs()
+	guilds := make([]apiGuild, 0, len(guildIDs))
+	for _, guildID := range guildIDs {
+		guilds = append(guilds, b.apiGuild(guildID, false))
+	}
+	writeJSON(w, http.StatusOK, guilds)
+}
+
+// handleAPICrashes lists the saved crash reports, newest first
+// GET /api/crashes
+func (b *Bot) handleAPICrashes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	summaries, err := crashSummaries(crashReportDir(b.cfg()))
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+// handleAPICrash returns a saved crash report
+// GET /api/crashes/{id}
+func (b *Bot) handleAPICrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	report, err := readCrashReport(crashReportDir(b.cfg()), strings.TrimPrefix(r.URL.Path, "/api/crashes/"))
+	if errors.Is(err, errCrashReportNotFound) {
+		writeAPIError(w, http.StatusNotFound, "crash report not found")
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleAPIGuild shows and controls a single guild
+//
+//	GET    /api/guilds/{id}                          state and settings
+//	GET    /api/guilds/{id}/channels                 voice channels
+//	POST   /api/guilds/{id}/radio                    {"channel_id": "..."} start the radio
+//	DELETE /api/guilds/{id}/radio                    stop the radio
+//	POST   /api/guilds/{id}/reconnect                reconnect to the current channel
+//	PUT    /api/guilds/{id}/station                  {"url": "..."} play a station, "" for RADIO_URL
+//	PUT    /api/guilds/{id}/volume                   {"percent": 100} set the volume, null for the default
+//	PATCH  /api/guilds/{id}/autoconnect              {"enabled": true, "rule": "most|priority|first"}
+//	POST   /api/guilds/{id}/autochannels             {"channel_id": "...", "priority": 0}
+//	DELETE /api/guilds/{id}/autochannels/{channel}   remove an auto-channel
+func (b *Bot) handleAPIGuild(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/guilds/"), "/"), "/")
+	guildID := parts[0]
+
+	if _, err := b.session.State.Guild(guildID); err != nil {
+		writeAPIError(w, http.StatusNotFound, "guild not found")
+		return
+	}
+
+	route := r.Method + " " + strings.Join(parts[1:], "/")
+	if len(parts) == 3 && parts[1] == "autochannels" {
+		route = r.Method + " autochannels/{channel}"
+	}
+
+	if r.Method != http.MethodGet {
+		b.log(guildID).WithField("remote", r.RemoteAddr).Infof("Admin API request %s %s", r.Method, r.URL.Path)
+	}
+
+	var err error
+	switch route {
+	case "GET ":
+		writeJSON(w, http.StatusOK, b.apiGuild(guildID, true))
+		return
+	case "GET channels":
+		writeJSON(w, http.StatusOK, b.voiceChannels(guildID))
+		return
+	case "POST radio":
+		var req struct {
+			ChannelID string `json:"channel_id"`
+		}
+		if !decodeAPIRequest(w, r, &req) {
+			return
+		}
+		if req.ChannelID == "" {
+			writeAPIError(w, http.StatusBadRequest, "channel_id is required")
+			return
+		}
+		err = b.playInChannel(guildID, req.ChannelID, "")
+	case "DELETE radio":
+		b.stopRadio(guildID)
+	case "POST reconnect":
+		err = b.reconnectGuild(guildID)
+	case "PUT station":
+		var req struct {
+			URL string `json:"url"`
+		}
+		if !decodeAPIRequest(w, r, &req) {
+			return
+		}
+		err = b.setStation(guildID, req.URL)
+	case "PUT volume":
+		var req struct {
+			Percent *int `json:"percent"`
+		}
+		if !decodeAPIRequest(w, r, &req) {
+			return
+		}
+		percent := -1
+		if req.Percent != nil {
+			if *req.Percent < 0 {
+				writeAPIError(w, http.StatusBadRequest, "percent must not be negative")
+				return
+			}
+			percent = *req.Percent
+		}
+		err = b.setVolume(guildID, percent)
+	case "PATCH autoconnect":
+		var req struct {
+			Enabled *bool   `json:"enabled"`
+			Rule    *string `json:"rule"`
+		}
+		if !decodeAPIRequest(w, r, &req) {
+			return
+		}
+		if req.Rule != nil {
+			_, err = b.setAutoChannelRule(guildID, *req.Rule)
+		}
+		if err == nil && req.Enabled != nil {
+			err = b.setAutoConnect(guildID, *req.Enabled)
+		}
+	case "POST autochannels":
+		var req struct {
+			ChannelID string `json:"channel_id"`
+			Priority  int    `json:"priority"`
+		}
+		if !decodeAPIRequest(w, r, &req) {
+			return
+		}
+		_, err = b.addAutoChannel(guildID, req.ChannelID, req.Priority)
+	case "DELETE autochannels/{channel}":
+		err = b.removeAutoChannel(guildID, parts[2])
+	default:
+		writeAPIError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if err != nil {
+		writeActionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.apiGuild(guildID, true))
+}
+
+// apiGuild describes a guild, with its settings if withConfig is set
+// Station URLs are redacted, they may hold credentials
+func (b *Bot) apiGuild(guildID string, withConfig bool) apiGuild {
+	guild := apiGuild{ID: guildID, Station: config.RedactURL(b.station(guildID)), Volume: radio.DefaultVolume}
+	if g, err := b.session.State.Guild(guildID); err == nil {
+		guild.Name = g.Name
+	}
+
+	state, exists := b.radioManager.Get(guildID)
+	if !exists {
+		return guild
+	}
+
+	guild.Volume = state.GetVolume()
+	guild.Active = state.IsActive()
+	guild.Idle = state.IsIdle()
+	guild.Muted = state.IsMuted()
+	guild.FollowUserID = state.GetFollowUserID()
+	guild.AutoConnectEnabled = state.IsAutoConnectEnabled()
+	guild.Session = state.GetSession()
+	if guild.Active {
+		guild.ChannelID = state.GetChannelID()
+		guild.Listeners = b.countUsersInChannelFromState(guildID, guild.ChannelID)
+		if channel, err := b.session.State.Channel(guild.ChannelID); err == nil {
+			guild.ChannelName = channel.Name
+		}
+	}
+	if stream, playing := b.streamer.Status(guildID); playing {
+		stream.Station = config.RedactURL(stream.Station)
+		guild.Stream = &stream
+	}
+	if since := state.FailingSince(); !since.IsZero() {
+		guild.FailingSince = &since
+	}
+	if withConfig {
+		guildConfig := b.radioManager.ExportGuild(guildID)
+		guildConfig.Station = config.RedactURL(guildConfig.Station)
+		guild.Config = &guildConfig
+		guild.Events = b.activity.Recent(guildID)
+	}
+	return guild
+}
+
+// botGuildIDs returns the IDs of the guilds the bot is in, sorted
+func (b *Bot) botGuildIDs() []string {
+	b.session.State.RLock()
+	guildIDs := make([]string, 0, len(b.session.State.Guilds))
+	for _, guild := range b.session.State.Guilds {
+		guildIDs = append(guildIDs, guild.ID)
+	}
+	b.session.State.RUnlock()
+	sort.Strings(guildIDs)
+	return guildIDs
+}
+
+// decodeAPIRequest decodes a JSON request body, answering 400 if it's invalid
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeActionError answers with the reason an action failed
+// Rejected actions are the client's fault, anything else is ours
+func writeActionError(w http.ResponseWriter, err error) {
+	var cmdErr *commandError
+	var joinErr *voiceJoinError
+	switch {
+	case errors.As(err, &cmdErr):
+		writeAPIError(w, http.StatusBadRequest, cmdErr.Reason)
+	case errors.As(err, &joinErr):
+		writeAPIError(w, http.StatusConflict, joinErr.Reason)
+	default:
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// writeAPIError writes an error as JSON
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// writeJSON writes a value as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,317 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/ankogit/4duk-discord-bot/internal/radio"
+)
+
+// requiredVoicePermissions are the permissions the bot needs to play radio in a channel
+var requiredVoicePermissions = []struct {
+	permission int64
+	name       string
+}{
+	{discordgo.PermissionViewChannel, "View Channel"},
+	{discordgo.PermissionVoiceConnect, "Connect"},
+	{discordgo.PermissionVoiceSpeak, "Speak"},
+}
+
+// onChannelDelete handles channel deletion
+// Removes the deleted channel from the auto-channels
+func (b *Bot) onChannelDelete(s *discordgo.Session, c *discordgo.ChannelDelete) {
+	if c.Channel == nil || c.GuildID == "" {
+		return
+	}
+
+	guildID := c.GuildID
+	state, exists := b.radioManager.Get(guildID)
+	if !exists || !state.RemoveAutoChannel(c.ID) {
+		return
+	}
+
+	b.log(guildID).Warnf("Auto-channel %s (%s) was deleted, removing it", c.Name, c.ID)
+	b.saveState(guildID)
+
+	b.notifyGuildAdmins(guildID, fmt.Sprintf(
+		"⚠️ Голосовой канал **%s**, выбранный для авто-подключения, был удалён и убран из списка. %s",
+		c.Name, b.autoChannelsLeftHint(state)))
+}
+
+// onChannelUpdate handles channel updates
+// Permission overwrites of an auto-channel may have changed
+func (b *Bot) onChannelUpdate(s *discordgo.Session, c *discordgo.ChannelUpdate) {
+	if c.Channel == nil || c.GuildID == "" {
+		return
+	}
+
+	state, exists := b.radioManager.Get(c.GuildID)
+	if !exists || !state.IsAutoChannel(c.ID) {
+		return
+	}
+
+	b.validateAutoChannels(c.GuildID)
+}
+
+// onGuildRoleUpdate handles role updates
+// Role permissions affect the bot's effective permissions in the auto-channels
+func (b *Bot) onGuildRoleUpdate(s *discordgo.Session, r *discordgo.GuildRoleUpdate) {
+	if r.GuildRole == nil {
+		return
+	}
+	b.validateAutoChannels(r.GuildID)
+}
+
+// onGuildRoleDelete handles role deletion
+func (b *Bot) onGuildRoleDelete(s *discordgo.Session, r *discordgo.GuildRoleDelete) {
+	b.validateAutoChannels(r.GuildID)
+}
+
+// validateAutoChannels checks that the saved auto-channels still exist and the bot can use them
+// Channels that are gone are removed, channels with missing permissions are skipped
+// until permissions are restored. Admins are notified once per change.
+func (b *Bot) validateAutoChannels(guildID string) {
+	state, exists := b.radioManager.Get(guildID)
+	if !exists {
+		return
+	}
+
+	channels := state.GetAutoChannels()
+	if len(channels) == 0 {
+		return
+	}
+
+	// Without the guild in state we can't tell a deleted channel from a missing cache
+	if _, err := b.session.State.Guild(guildID); err != nil {
+		b.log(guildID).WithError(err).Debug("Guild not in state, skipping auto-channel validation")
+		return
+	}
+
+	for _, autoChannel := range channels {
+		channelID := autoChannel.ChannelID
+
+		channel, err := b.session.State.Channel(channelID)
+		if err != nil || channel.Type != discordgo.ChannelTypeGuildVoice {
+			b.log(guildID).Warnf("Auto-channel %s no longer exists or is not a voice channel, removing it", channelID)
+
+			state.RemoveAutoChannel(channelID)
+			b.saveState(guildID)
+
+			b.notifyGuildAdmins(guildID, fmt.Sprintf(
+				"⚠️ Канал `%s`, выбранный для авто-подключения, больше не существует или не является голосовым, он убран из списка. %s",
+				channelID, b.autoChannelsLeftHint(state)))
+			continue
+		}
+
+		missing, err := b.missingVoicePermissions(channelID)
+		if err != nil {
+			b.log(guildID).WithError(err).Debugf("Failed to compute permissions for auto-channel %s", channelID)
+			continue
+		}
+
+		if len(missing) > 0 {
+			// Already skipped - nothing to repeat
+			if autoChannel.DisabledReason == radio.DisabledReasonPermissions {
+				continue
+			}
+
+			b.log(guildID).Warnf("Missing permissions %v in auto-channel %s, skipping it", missing, channel.Name)
+
+			state.SetAutoChannelDisabledReason(channelID, radio.DisabledReasonPermissions)
+			b.saveState(guildID)
+
+			b.notifyGuildAdmins(guildID, fmt.Sprintf(
+				"⚠️ У бота нет прав **%s** в канале **%s**, поэтому авто-подключение к нему отключено. "+
+					"Выдайте права — оно включится само.", strings.Join(missing, ", "), channel.Name))
+			continue
+		}
+
+		// Permissions are back - repair the configuration we disabled ourselves
+		if autoChannel.DisabledReason == radio.DisabledReasonPermissions {
+			b.log(guildID).Infof("Permissions restored in auto-channel %s, using it again", channel.Name)
+
+			state.SetAutoChannelDisabledReason(channelID, "")
+			b.saveState(guildID)
+
+			b.notifyGuildAdmins(guildID, fmt.Sprintf(
+				"✅ Права в канале **%s** восстановлены, авто-подключение к нему снова работает.", channel.Name))
+		}
+	}
+}
+
+// pickAutoChannel picks the occupied auto-channel to play in according to the guild's rule
+// Returns an empty channel ID if none of them satisfies the auto-connect rules
+func (b *Bot) pickAutoChannel(guildID string) (string, int) {
+	state := b.radioManager.GetOrCreate(guildID)
+
+	if !b.isAutoConnectTimeAllowed(guildID) {
+		b.log(guildID).Debug("Outside of auto-connect days and hours")
+		return "", 0
+	}
+
+	listeners := func(channelID string) int {
+		// Never auto-connect to the AFK channel
+		if b.isAFKChannel(guildID, channelID) {
+			return 0
+		}
+		return b.qualifyingListeners(guildID, channelID)
+	}
+
+	channel, count := radio.PickAutoChannel(state.GetAutoChannels(), state.GetAutoChannelRule(), listeners)
+	if channel == nil {
+		return "", 0
+	}
+	return channel.ChannelID, count
+}
+
+// isUsableAutoChannel reports whether a channel is an auto-channel the bot may join
+func (b *Bot) isUsableAutoChannel(guildID, channelID string) bool {
+	if b.isAFKChannel(guildID, channelID) {
+		return false
+	}
+	for _, channel := range b.radioManager.GetOrCreate(guildID).GetAutoChannels() {
+		if channel.ChannelID == channelID {
+			return channel.DisabledReason == ""
+		}
+	}
+	return false
+}
+
+// onAutoChannelEmptied moves the radio to another occupied auto-channel,
+// or leaves after the grace period if there is none
+func (b *Bot) onAutoChannelEmptied(guildID, channelID string) {
+	// The followed user decides where the radio goes
+	if b.isFollowing(guildID) {
+		return
+	}
+
+	if target, userCount := b.pickAutoChannel(guildID); target != "" && target != channelID {
+		b.log(guildID).Infof("Channel %s is empty, moving to auto-channel %s (%d listeners)", channelID, target, userCount)
+		b.startAutoConnect(guildID, target, 0)
+		return
+	}
+	b.scheduleIdleLeave(guildID, channelID)
+}
+
+// startAutoConnect connects to an auto-channel and starts the radio in the background
+// With a debounce the connect is skipped if the channel empties again meanwhile
+func (b *Bot) startAutoConnect(guildID, channelID string, debounce time.Duration) {
+	b.log(guildID).Infof("Starting auto-connect goroutine for channel %s", channelID)
+	b.goGuild(guildID, "auto-connect", func() {
+		state := b.radioManager.GetOrCreate(guildID)
+
+		if !state.BeginConnect() {
+			b.log(guildID).Info("Connect already in progress, skipping")
+			return
+		}
+		defer state.EndConnect()
+
+		// Debounce, so a join and leave within a short window doesn't connect
+		if debounce > 0 {
+			select {
+			case <-time.After(debounce):
+			case <-b.ctx.Done():
+				return
+			}
+			if b.qualifyingListeners(guildID, channelID) == 0 {
+				b.log(guildID).Infof("Channel %s no longer qualifies after debounce window, not connecting", channelID)
+				return
+			}
+		}
+
+		// Double-check auto-connect is still enabled and channel is still configured
+		if !state.IsAutoConnectEnabled() {
+			b.log(guildID).Warn("Auto-connect disabled in goroutine, aborting")
+			return
+		}
+		if !state.IsAutoChannel(channelID) {
+			b.log(guildID).Warnf("Channel %s is no longer an auto-channel, aborting", channelID)
+			return
+		}
+
+		// Check if already active
+		if state.IsActive() {
+			botVS, err := b.session.State.VoiceState(guildID, b.session.State.User.ID)
+			if err == nil && botVS != nil && botVS.ChannelID == channelID {
+				b.log(guildID).Infof("Already connected to channel %s, skipping", channelID)
+				state.StopIdle()
+				return
+			}
+		}
+
+		err := b.checkVoiceJoin(guildID, channelID)
+		if err == nil {
+			err = b.connectAndPlay(guildID, channelID, "")
+		}
+		if err != nil {
+			b.log(guildID).WithError(err).Error("Failed to auto-connect to channel")
+			// Stop retrying on every join if the channel became unusable
+			b.validateAutoChannels(guildID)
+		}
+	})
+}
+
+// autoChannelsLeftHint tells admins what to do once an auto-channel was removed
+func (b *Bot) autoChannelsLeftHint(state *radio.State) string {
+	if len(state.GetAutoChannels()) == 0 {
+		return "Других каналов нет — добавьте новый командой `!autochannel add <ID_канала>`."
+	}
+	return "Остальные каналы для авто-подключения продолжают работать."
+}
+
+// missingVoicePermissions returns names of the voice permissions the bot lacks in a channel
+func (b *Bot) missingVoicePermissions(channelID string) ([]string, error) {
+	perms, err := b.session.State.UserChannelPermissions(b.session.State.User.ID, channelID)
+	if err != nil {
+		return nil, err
+	}
+	return missingPermissionNames(perms), nil
+}
+
+// missingPermissionNames returns names of the required voice permissions absent from perms
+func missingPermissionNames(perms int64) []string {
+	// Administrator implies every permission
+	if perms&discordgo.PermissionAdministrator != 0 {
+		return nil
+	}
+
+	var missing []string
+	for _, p := range requiredVoicePermissions {
+		if perms&p.permission == 0 {
+			missing = append(missing, p.name)
+		}
+	}
+	return missing
+}
+
+// notifyGuildAdmins sends a message to the guild's system channel,
+// falling back to a direct message to the guild owner
+func (b *Bot) notifyGuildAdmins(guildID, message string) {
+	guild, err := b.session.State.Guild(guildID)
+	if err != nil {
+		b.log(guildID).WithError(err).Warn("Failed to get guild to notify admins")
+		return
+	}
+
+	if guild.SystemChannelID != "" {
+		perms, err := b.session.State.UserChannelPermissions(b.session.State.User.ID, guild.SystemChannelID)
+		if err == nil && perms&discordgo.PermissionSendMessages != 0 {
+			if _, err := b.session.ChannelMessageSend(guild.SystemChannelID, message); err == nil {
+				return
+			}
+		}
+	}
+
+	dm, err := b.session.UserChannelCreate(guild.OwnerID)
+	if err != nil {
+		b.log(guildID).WithError(err).Warn("Failed to open DM with guild owner")
+		return
+	}
+
+	if _, err := b.session.ChannelMessageSend(dm.ID, fmt.Sprintf("**%s**: %s", guild.Name, message)); err != nil {
+		b.log(guildID).WithError(err).Warn("Failed to notify guild owner")
+	}
+}
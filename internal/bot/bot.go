@@ -3,7 +3,10 @@ package bot
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -11,20 +14,45 @@ import (
 
 	"github.com/ankogit/4duk-discord-bot/internal/audio"
 	"github.com/ankogit/4duk-discord-bot/internal/config"
+	"github.com/ankogit/4duk-discord-bot/internal/metrics"
 	"github.com/ankogit/4duk-discord-bot/internal/radio"
 )
 
 // Bot represents the Discord bot
 type Bot struct {
-	session      *discordgo.Session
-	config       *config.Config
-	radioManager *radio.Manager
-	streamer     *audio.Streamer
-	encoderPool  *audio.EncoderPool
-	ctx          context.Context
-	cancel       context.CancelFunc
-	wg           sync.WaitGroup
-	logger       *logrus.Logger
+	session          *discordgo.Session
+	config           atomic.Pointer[config.Config] // Replaced by a reload, see cfg
+	reloadMu         sync.Mutex                    // Serializes reloads
+	radioManager     *radio.Manager
+	streamer         *audio.Streamer
+	encoderPool      *audio.EncoderPool
+	members          *memberCache
+	metrics          *metrics.Metrics
+	httpServer       *http.Server
+	adminServer      *http.Server
+	controlServer    *http.Server
+	startedAt        time.Time
+	heartbeat        atomic.Int64 // Last tick of the voice check loop, unix nanoseconds
+	gatewayConnected atomic.Bool
+	disconnects      atomic.Int64 // Gateway disconnects so far, tells the watchdog of a disconnect apart from later ones
+	archive          *radio.Archive
+	readyOnce        sync.Once // Startup work done on the first Ready only
+	// Settings imports waiting for confirmation, by guild ID
+	pendingImports map[string]*pendingImport
+	importsMu      sync.Mutex
+	// Guilds with debug logging turned on at runtime
+	debugGuilds map[string]bool
+	debugMu     sync.RWMutex
+	debugLogger *logrus.Logger
+	activity    *activityLog
+	crashLog    *crashLog
+	failed      chan error // Failures that need a restart of the bot, see Failed
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	logger      *logrus.Logger
+	logOutput   *logOutput // Writes the entries of logger at the log level
+	debugOutput *logOutput // Writes the entries of debugLogger
 }
 
 // New creates a new bot instance
@@ -38,39 +66,113 @@ func New(cfg *config.Config, logger *logrus.Logger) (*Bot, error) {
 	session.Identify.Intents = discordgo.IntentsGuilds |
 		discordgo.IntentsGuildMessages |
 		discordgo.IntentsGuildVoiceStates
+	// Member events keep the member cache fresh, the intent is privileged
+	// and has to be enabled in the developer portal first, so it is opt-in
+	memberTTL := time.Duration(0)
+	if cfg.MembersIntent == "on" {
+		session.Identify.Intents |= discordgo.IntentsGuildMembers
+	} else {
+		memberTTL = cfg.MemberCacheTTL
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	encoderPool := audio.NewEncoderPool()
-	radioManager := radio.NewManager()
-	streamer := audio.NewStreamer(cfg.RadioURL, encoderPool, logger)
+	storage, err := radio.OpenStorage(cfg.StorageBackend, cfg.DataDir)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	radioManager, err := radio.NewManager(storage)
+	if err != nil {
+		cancel()
+		_ = storage.Close()
+		return nil, err
+	}
+
+	archive, err := radio.OpenArchive(cfg.StorageBackend, cfg.DataDir)
+	if err != nil {
+		cancel()
+		_ = storage.Close()
+		return nil, fmt.Errorf("failed to open guild archive: %w", err)
+	}
+
+	botMetrics := metrics.New(cfg.MetricsGuildLabels)
+
+	// Messages logged for a guild are its recent activity on the dashboard
+	activity := newActivityLog()
+	level, err := logrus.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+	debugLogger, debugOutput := newDebugLogger(logger, level, activity)
+	logger, logOutput := newBotLogger(logger, level, activity)
 
+	encoderPool := audio.NewEncoderPool()
 	bot := &Bot{
-		session:      session,
-		config:       cfg,
-		radioManager: radioManager,
-		streamer:     streamer,
-		encoderPool:  encoderPool,
-		ctx:          ctx,
-		cancel:       cancel,
-		logger:       logger,
+		session:        session,
+		radioManager:   radioManager,
+		encoderPool:    encoderPool,
+		members:        newMemberCache(memberTTL, cfg.MemberRequestInterval),
+		metrics:        botMetrics,
+		startedAt:      time.Now(),
+		archive:        archive,
+		pendingImports: make(map[string]*pendingImport),
+		debugGuilds:    make(map[string]bool),
+		debugLogger:    debugLogger,
+		activity:       activity,
+		crashLog:       newCrashLog(),
+		failed:         make(chan error, 1),
+		ctx:            ctx,
+		cancel:         cancel,
+		logger:         logger,
+		logOutput:      logOutput,
+		debugOutput:    debugOutput,
+	}
+	bot.config.Store(cfg)
+	bot.streamer = audio.NewStreamer(streamerConfig(cfg), encoderPool, botMetrics, bot.log)
+
+	if err := botMetrics.RegisterListeners(bot.activeListeners); err != nil {
+		cancel()
+		_ = storage.Close()
+		return nil, fmt.Errorf("failed to register metrics: %w", err)
 	}
 
-	// Register event handlers
-	session.AddHandler(bot.onReady)
-	session.AddHandler(bot.onMessageCreate)
-	session.AddHandler(bot.onVoiceStateUpdate)
+	// Register event handlers, a panic in one is recovered instead of crashing the bot
+	session.AddHandler(supervised(bot, bot.onReady))
+	session.AddHandler(supervised(bot, bot.onConnect))
+	session.AddHandler(supervised(bot, bot.onDisconnect))
+	session.AddHandler(supervised(bot, bot.onMessageCreate))
+	session.AddHandler(supervised(bot, bot.onVoiceStateUpdate))
+	session.AddHandler(supervised(bot, bot.onChannelDelete))
+	session.AddHandler(supervised(bot, bot.onChannelUpdate))
+	session.AddHandler(supervised(bot, bot.onGuildRoleUpdate))
+	session.AddHandler(supervised(bot, bot.onGuildRoleDelete))
+	session.AddHandler(supervised(bot, bot.onGuildCreate))
+	session.AddHandler(supervised(bot, bot.onGuildDelete))
+	session.AddHandler(supervised(bot, bot.onGuildCreateUnarchive))
+	session.AddHandler(supervised(bot, bot.onGuildMemberAdd))
+	session.AddHandler(supervised(bot, bot.onGuildMemberUpdate))
+	session.AddHandler(supervised(bot, bot.onGuildMemberRemove))
+	session.AddHandler(supervised(bot, bot.onGuildMembersChunk))
 
 	return bot, nil
 }
 
-// Start starts the bot
-func (b *Bot) Start() error {
+// Start starts the bot, it reloads its configuration on every signal received from reload
+func (b *Bot) Start(reload <-chan os.Signal) error {
 	err := b.session.Open()
 	if err != nil {
 		return fmt.Errorf("failed to open session: %w", err)
 	}
 
+	b.startHTTPServer()
+	b.startAdminServer()
+	b.startControlServer()
+
+	b.wg.Add(1)
+	go b.reloadOnSignal(reload)
+
 	b.logger.Info("Bot started successfully")
 	return nil
 }
@@ -94,7 +196,7 @@ func (b *Bot) Stop() error {
 			func() {
 				defer func() {
 					if r := recover(); r != nil {
-						b.logger.Debugf("[%s] Panic during disconnect (ignored): %v", guildID, r)
+						b.log(guildID).Debugf("Panic during disconnect (ignored): %v", r)
 					}
 				}()
 				_ = vc.Disconnect(context.Background())
@@ -105,6 +207,8 @@ func (b *Bot) Stop() error {
 		b.encoderPool.Remove(guildID)
 	}
 
+	b.stopHTTPServers()
+
 	// Close Discord session
 	err := b.session.Close()
 	if err != nil {
@@ -121,10 +225,52 @@ func (b *Bot) Stop() error {
 	select {
 	case <-done:
 		b.logger.Info("All goroutines finished")
-	case <-time.After(10 * time.Second):
+	case <-time.After(b.cfg().ShutdownTimeout):
 		b.logger.Warn("Timeout waiting for goroutines to finish")
 	}
 
+	if err := b.radioManager.Close(); err != nil {
+		b.logger.WithError(err).Error("Error closing storage")
+	}
+
 	return nil
 }
 
+// cfg returns the current configuration
+func (b *Bot) cfg() *config.Config {
+	return b.config.Load()
+}
+
+// Config returns the current configuration, including reloaded settings
+func (b *Bot) Config() *config.Config {
+	return b.cfg()
+}
+
+// streamerConfig returns the settings of the streamer
+func streamerConfig(cfg *config.Config) audio.StreamerConfig {
+	return audio.StreamerConfig{
+		RadioURL:       cfg.RadioURL,
+		StabilizeDelay: cfg.StreamStabilizeDelay,
+		SendTimeout:    cfg.StreamSendTimeout,
+		StallThreshold: cfg.StreamStallThreshold,
+	}
+}
+
+// saveState persists the configuration of a guild, logging failures
+func (b *Bot) saveState(guildID string) error {
+	err := b.radioManager.SaveState(guildID)
+	if err != nil {
+		b.log(guildID).WithError(err).Error("Failed to save guild configuration")
+	}
+	return err
+}
+
+// saveCommandState persists the configuration changed by a command
+// Tells the user if it couldn't be saved and returns false
+func (b *Bot) saveCommandState(s *discordgo.Session, textChannelID, guildID string) bool {
+	if err := b.saveState(guildID); err != nil {
+		s.ChannelMessageSend(textChannelID, notSavedMessage)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Retention policies for the configuration of guilds the bot left
+const (
+	RetentionArchive = "archive"
+	RetentionDelete  = "delete"
+)
+
+// onGuildDelete cleans up after a guild the bot was removed from or that was deleted
+func (b *Bot) onGuildDelete(s *discordgo.Session, g *discordgo.GuildDelete) {
+	// Unavailable means an outage, the guild comes back with a guild create
+	if g.Guild == nil || g.Unavailable {
+		return
+	}
+	b.log(g.ID).Info("Bot was removed from guild or guild was deleted, cleaning up")
+	b.cleanupGuild(g.ID)
+}
+
+// onGuildCreateUnarchive restores the configuration of a guild the bot left and rejoined
+func (b *Bot) onGuildCreateUnarchive(s *discordgo.Session, g *discordgo.GuildCreate) {
+	if g.Guild == nil {
+		return
+	}
+	restored, err := b.radioManager.UnarchiveGuild(g.ID, b.archive)
+	if err != nil {
+		b.log(g.ID).WithError(err).Error("Failed to restore archived configuration")
+	}
+	if restored {
+		b.log(g.ID).Info("Bot rejoined guild, restored archived configuration")
+	}
+}
+
+// cleanupGuild releases everything held for a guild the bot is no longer in,
+// then archives or deletes its configuration according to the retention policy
+func (b *Bot) cleanupGuild(guildID string) {
+	if state, exists := b.radioManager.Get(guildID); exists {
+		state.SetActive(false)
+		state.StopIdle()
+		state.StopStream()
+	}
+
+	// The voice connection is gone with the guild, drop what's left of it
+	if vc, exists := b.session.VoiceConnections[guildID]; exists {
+		// Remove from map first to prevent Kill() panic
+		delete(b.session.VoiceConnections, guildID)
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					b.log(guildID).Debugf("Panic during disconnect (ignored): %v", r)
+				}
+			}()
+			_ = vc.Disconnect(b.ctx)
+		}()
+	}
+
+	b.encoderPool.Remove(guildID)
+	b.members.removeGuild(guildID)
+
+	b.importsMu.Lock()
+	delete(b.pendingImports, guildID)
+	b.importsMu.Unlock()
+
+	if _, exists := b.radioManager.Get(guildID); !exists {
+		return
+	}
+
+	if b.cfg().GuildRetention == RetentionDelete {
+		if err := b.radioManager.Remove(guildID); err != nil {
+			b.log(guildID).WithError(err).Error("Failed to delete guild configuration")
+			return
+		}
+		b.log(guildID).Info("Guild configuration deleted")
+		return
+	}
+
+	if err := b.radioManager.ArchiveGuild(guildID, b.archive); err != nil {
+		b.log(guildID).WithError(err).Error("Failed to archive guild configuration")
+		return
+	}
+	b.log(guildID).Infof("Guild configuration archived for %v", b.cfg().GuildArchiveTTL)
+}
+
+// guildSweepLoop periodically reconciles saved guilds with the guilds the bot is in
+func (b *Bot) guildSweepLoop() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.cfg().GuildSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+			b.sweepGuilds()
+		}
+	}
+}
+
+// sweepGuilds cleans up guilds the bot left without an event, e.g. while it was offline,
+// and purges archived configurations past the retention period
+func (b *Bot) sweepGuilds() {
+	// Without guilds in state every saved guild would look abandoned
+	if len(b.session.State.Guilds) == 0 {
+		b.logger.Debug("No guilds in state, skipping guild sweep")
+		return
+	}
+
+	for _, guildID := range b.radioManager.GetAllGuildIDs() {
+		if _, err := b.session.State.Guild(guildID); err == nil {
+			continue
+		}
+		b.log(guildID).Info("Bot is no longer in guild, cleaning up")
+		b.cleanupGuild(guildID)
+	}
+
+	purged, err := b.archive.Purge(b.cfg().GuildArchiveTTL)
+	if err != nil {
+		b.logger.WithError(err).Error("Failed to purge archived guild configurations")
+	}
+	if purged > 0 {
+		b.logger.Infof("Purged %d archived guild configurations older than %v", purged, b.cfg().GuildArchiveTTL)
+	}
+}
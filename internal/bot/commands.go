@@ -1,11 +1,13 @@
 package bot
 
 import (
-	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/bwmarrin/discordgo"
+
+	"github.com/ankogit/4duk-discord-bot/internal/radio"
 )
 
 // handleJoin handles the !join command
@@ -22,7 +24,7 @@ func (b *Bot) handleJoin(s *discordgo.Session, m *discordgo.MessageCreate) {
 
 	channel, err := s.Channel(vs.ChannelID)
 	if err != nil {
-		b.logger.WithError(err).Errorf("[%s] Failed to get channel", guildID)
+		b.log(guildID).WithError(err).Error("Failed to get channel")
 		s.ChannelMessageSend(channelID, "Ошибка при получении информации о канале.")
 		return
 	}
@@ -32,13 +34,19 @@ func (b *Bot) handleJoin(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
+	// Answer with a specific reason before attempting the join
+	if err := b.checkVoiceJoin(guildID, vs.ChannelID); err != nil {
+		s.ChannelMessageSend(channelID, joinFailureMessage(err, "Не удалось подключиться к голосовому каналу."))
+		return
+	}
+
 	state := b.radioManager.GetOrCreate(guildID)
 	state.SetChannelID(vs.ChannelID)
 
 	vc, err := b.connectToChannel(s, m.GuildID, vs.ChannelID)
 	if err != nil {
-		b.logger.WithError(err).Errorf("[%s] Failed to connect to channel", guildID)
-		s.ChannelMessageSend(channelID, fmt.Sprintf("Не удалось подключиться к голосовому каналу: %v", err))
+		b.log(guildID).WithError(err).Error("Failed to connect to channel")
+		s.ChannelMessageSend(channelID, joinFailureMessage(err, fmt.Sprintf("Не удалось подключиться к голосовому каналу: %v", err)))
 		return
 	}
 
@@ -49,7 +57,6 @@ func (b *Bot) handleJoin(s *discordgo.Session, m *discordgo.MessageCreate) {
 
 // handleRadio handles the !radio command
 func (b *Bot) handleRadio(s *discordgo.Session, m *discordgo.MessageCreate) {
-	guildID := m.GuildID
 	channelID := m.ChannelID
 
 	// Check if user is in a voice channel
@@ -59,115 +66,154 @@ func (b *Bot) handleRadio(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
-	channel, err := s.Channel(vs.ChannelID)
-	if err != nil {
-		b.logger.WithError(err).Errorf("[%s] Failed to get channel", guildID)
-		s.ChannelMessageSend(channelID, "Ошибка при получении информации о канале.")
+	if err := b.playInChannel(m.GuildID, vs.ChannelID, m.Author.ID); err != nil {
+		b.log(m.GuildID).WithError(err).Error("Failed to start radio")
+		s.ChannelMessageSend(channelID, joinFailureMessage(err, "Не удалось подключиться к голосовому каналу для радио."))
 		return
 	}
 
-	if channel.Type != discordgo.ChannelTypeGuildVoice {
-		s.ChannelMessageSend(channelID, "Это не голосовой канал!")
+	s.ChannelMessageSend(channelID, "🎵 Вещаю радио!")
+}
+
+// handleStop handles the !stop command
+func (b *Bot) handleStop(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if !b.stopRadio(m.GuildID) {
+		s.ChannelMessageSend(m.ChannelID, "Я не в голосовом канале.")
 		return
 	}
 
-	state := b.radioManager.GetOrCreate(guildID)
-	state.SetActive(true)
-	state.SetChannelID(vs.ChannelID)
-	state.ResetReconnectAttempts()
+	s.ChannelMessageSend(m.ChannelID, "Отключился.")
+}
 
-	vc, err := b.connectToChannel(s, m.GuildID, vs.ChannelID)
-	if err != nil {
-		b.logger.WithError(err).Errorf("[%s] Failed to connect to channel", guildID)
-		s.ChannelMessageSend(channelID, "Не удалось подключиться к голосовому каналу для радио.")
-		return
-	}
+// handleSetChannel handles the !setchannel command
+// Sets the only channel for auto-join when users are present
+func (b *Bot) handleSetChannel(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := m.GuildID
+	textChannelID := m.ChannelID
 
-	if vc == nil {
-		s.ChannelMessageSend(channelID, "Не удалось подключиться к голосовому каналу для радио.")
+	// Parse command arguments
+	parts := strings.Fields(m.Content)
+	if len(parts) < 2 {
+		s.ChannelMessageSend(textChannelID, "Использование: `!setchannel <ID_канала>`")
 		return
 	}
 
-	err = b.startRadio(vc, guildID)
+	channel, err := b.setAutoChannel(guildID, parts[1])
 	if err != nil {
-		b.logger.WithError(err).Errorf("[%s] Failed to start radio", guildID)
-		s.ChannelMessageSend(channelID, fmt.Sprintf("Ошибка при запуске радио: %v", err))
+		s.ChannelMessageSend(textChannelID, actionFailureMessage(err, "Не удалось установить канал."))
 		return
 	}
 
-	s.ChannelMessageSend(channelID, "🎵 Вещаю радио!")
+	s.ChannelMessageSend(textChannelID, fmt.Sprintf("✅ Авто-подключение установлено на канал: **%s** (включено)", channel.Name))
 }
 
-// handleStop handles the !stop command
-func (b *Bot) handleStop(s *discordgo.Session, m *discordgo.MessageCreate) {
+// handleAutoChannel handles the !autochannel command
+// Manages the list of auto-join channels and how to pick among them
+func (b *Bot) handleAutoChannel(s *discordgo.Session, m *discordgo.MessageCreate) {
 	guildID := m.GuildID
-	channelID := m.ChannelID
-
+	textChannelID := m.ChannelID
 	state := b.radioManager.GetOrCreate(guildID)
-	state.SetActive(false)
-	state.ResetReconnectAttempts()
 
-	vc, exists := s.VoiceConnections[guildID]
-	if !exists || vc == nil {
-		s.ChannelMessageSend(channelID, "Я не в голосовом канале.")
+	usage := "Использование:\n" +
+		"`!autochannel add <ID_канала> [приоритет]` — добавить канал\n" +
+		"`!autochannel remove <ID_канала>` — убрать канал\n" +
+		"`!autochannel rule most|priority|first` — больше слушателей, выше приоритет или первый занятый"
+
+	parts := strings.Fields(m.Content)
+	if len(parts) < 2 {
+		s.ChannelMessageSend(textChannelID, b.describeAutoChannels(s, state)+"\n\n"+usage)
 		return
 	}
 
-	// Remove from map first to prevent Kill() panic
-	delete(s.VoiceConnections, guildID)
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				b.logger.Debugf("[%s] Panic during disconnect (ignored): %v", guildID, r)
+	switch strings.ToLower(parts[1]) {
+	case "add":
+		if len(parts) < 3 {
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+		channelID := parts[2]
+
+		priority := 0
+		if len(parts) > 3 {
+			p, err := strconv.Atoi(parts[3])
+			if err != nil {
+				s.ChannelMessageSend(textChannelID, "Приоритет должен быть числом.")
+				return
 			}
-		}()
-		_ = vc.Disconnect(context.Background())
-	}()
+			priority = p
+		}
 
-	// Cleanup encoder
-	b.encoderPool.Remove(guildID)
+		channel, err := b.addAutoChannel(guildID, channelID, priority)
+		if err != nil {
+			s.ChannelMessageSend(textChannelID, actionFailureMessage(err, "Не удалось добавить канал."))
+			return
+		}
 
-	s.ChannelMessageSend(channelID, "Отключился.")
-}
+		s.ChannelMessageSend(textChannelID, fmt.Sprintf("✅ Канал **%s** добавлен в авто-подключение (приоритет %d)", channel.Name, priority))
+	case "remove":
+		if len(parts) < 3 {
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+		if err := b.removeAutoChannel(guildID, parts[2]); err != nil {
+			s.ChannelMessageSend(textChannelID, actionFailureMessage(err, "Не удалось убрать канал."))
+			return
+		}
 
-// handleSetChannel handles the !setchannel command
-// Sets the channel for auto-join when users are present
-func (b *Bot) handleSetChannel(s *discordgo.Session, m *discordgo.MessageCreate) {
-	guildID := m.GuildID
-	textChannelID := m.ChannelID
+		s.ChannelMessageSend(textChannelID, "✅ Канал убран из авто-подключения")
+	case "rule":
+		if len(parts) < 3 {
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+		if _, exists := autoChannelRules[strings.ToLower(parts[2])]; !exists {
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+		rule, err := b.setAutoChannelRule(guildID, parts[2])
+		if err != nil {
+			s.ChannelMessageSend(textChannelID, actionFailureMessage(err, usage))
+			return
+		}
 
-	// Parse command arguments
-	parts := strings.Fields(m.Content)
-	if len(parts) < 2 {
-		s.ChannelMessageSend(textChannelID, "Использование: `!setchannel <ID_канала>`")
-		return
+		s.ChannelMessageSend(textChannelID, fmt.Sprintf("✅ Правило выбора канала: **%s**", autoChannelRuleName(rule)))
+	default:
+		s.ChannelMessageSend(textChannelID, usage)
 	}
+}
 
-	// Extract channel ID (second part after command)
-	channelID := parts[1]
-
-	// Get channel info to verify it exists and is a voice channel
-	channel, err := s.Channel(channelID)
-	if err != nil {
-		b.logger.WithError(err).Debugf("[%s] Failed to get channel info", guildID)
-		s.ChannelMessageSend(textChannelID, "Канал не найден. Проверьте ID канала.")
-		return
+// describeAutoChannels lists the auto-join channels and the selection rule
+func (b *Bot) describeAutoChannels(s *discordgo.Session, state *radio.State) string {
+	channels := state.GetAutoChannels()
+	if len(channels) == 0 {
+		return "Каналы: не установлены"
 	}
 
-	if channel.Type != discordgo.ChannelTypeGuildVoice {
-		s.ChannelMessageSend(textChannelID, "Это не голосовой канал!")
-		return
+	message := "Каналы:"
+	for _, autoChannel := range channels {
+		name := fmt.Sprintf("`%s` (канал не найден)", autoChannel.ChannelID)
+		if channel, err := s.State.Channel(autoChannel.ChannelID); err == nil {
+			name = fmt.Sprintf("**%s** (`%s`)", channel.Name, autoChannel.ChannelID)
+		}
+		message += fmt.Sprintf("\n• %s, приоритет %d", name, autoChannel.Priority)
+		if autoChannel.DisabledReason == radio.DisabledReasonPermissions {
+			message += " — пропускается: у бота нет прав"
+		}
 	}
+	message += fmt.Sprintf("\nПравило выбора: **%s**", autoChannelRuleName(state.GetAutoChannelRule()))
+	return message
+}
 
-	// Save auto-channel
-	state := b.radioManager.GetOrCreate(guildID)
-	state.SetAutoChannelID(channelID)
-	// Enable auto-connect when setting channel
-	state.SetAutoConnectEnabled(true)
-	b.radioManager.SaveState(guildID)
-
-	s.ChannelMessageSend(textChannelID, fmt.Sprintf("✅ Авто-подключение установлено на канал: **%s** (включено)", channel.Name))
-	b.logger.Infof("[%s] Auto-channel set to %s (%s)", guildID, channel.Name, channelID)
+// autoChannelRuleName returns a human readable name of an auto-channel rule
+func autoChannelRuleName(rule string) string {
+	switch rule {
+	case radio.RulePriority:
+		return "наивысший приоритет"
+	case radio.RuleFirstOccupied:
+		return "первый занятый"
+	default:
+		return "больше всего слушателей"
+	}
 }
 
 // handleAutoConnect handles the !autoconnect command
@@ -181,26 +227,14 @@ func (b *Bot) handleAutoConnect(s *discordgo.Session, m *discordgo.MessageCreate
 	if len(parts) < 2 {
 		state := b.radioManager.GetOrCreate(guildID)
 		enabled := state.IsAutoConnectEnabled()
-		autoChannelID := state.GetAutoChannelID()
 
 		status := "выключено"
 		if enabled {
 			status = "включено"
 		}
 
-		message := fmt.Sprintf("Авто-подключение: **%s**", status)
-
-		if autoChannelID != "" {
-			// Get channel info
-			channel, err := s.Channel(autoChannelID)
-			if err == nil && channel != nil {
-				message += fmt.Sprintf("\nКанал: **%s** (`%s`)", channel.Name, autoChannelID)
-			} else {
-				message += fmt.Sprintf("\nКанал: `%s` (канал не найден)", autoChannelID)
-			}
-		} else {
-			message += "\nКанал: не установлен"
-		}
+		message := fmt.Sprintf("Авто-подключение: **%s**\n", status)
+		message += b.describeAutoChannels(s, state)
 
 		message += "\n\nИспользование: `!autoconnect on` или `!autoconnect off`"
 		s.ChannelMessageSend(textChannelID, message)
@@ -212,24 +246,58 @@ func (b *Bot) handleAutoConnect(s *discordgo.Session, m *discordgo.MessageCreate
 
 	switch action {
 	case "on", "enable", "вкл", "да":
-		state.SetAutoConnectEnabled(true)
-		b.radioManager.SaveState(guildID)
-		autoChannelID := state.GetAutoChannelID()
-		if autoChannelID != "" {
-			channel, _ := s.Channel(autoChannelID)
-			channelName := autoChannelID
-			if channel != nil {
-				channelName = channel.Name
-			}
-			s.ChannelMessageSend(textChannelID, fmt.Sprintf("✅ Авто-подключение **включено** для канала: **%s**", channelName))
+		if err := b.setAutoConnect(guildID, true); err != nil {
+			s.ChannelMessageSend(textChannelID, actionFailureMessage(err, "Не удалось включить авто-подключение."))
+			return
+		}
+		if len(state.GetAutoChannels()) > 0 {
+			s.ChannelMessageSend(textChannelID, "✅ Авто-подключение **включено**\n"+b.describeAutoChannels(s, state))
 		} else {
 			s.ChannelMessageSend(textChannelID, "✅ Авто-подключение **включено**. Установите канал командой `!setchannel <ID>`")
 		}
 	case "off", "disable", "выкл", "нет":
-		state.SetAutoConnectEnabled(false)
-		b.radioManager.SaveState(guildID)
+		if err := b.setAutoConnect(guildID, false); err != nil {
+			s.ChannelMessageSend(textChannelID, actionFailureMessage(err, "Не удалось выключить авто-подключение."))
+			return
+		}
 		s.ChannelMessageSend(textChannelID, "❌ Авто-подключение **выключено**")
 	default:
 		s.ChannelMessageSend(textChannelID, "Использование: `!autoconnect on` или `!autoconnect off`")
 	}
 }
+
+// handleStatus handles the !status command
+// Shows what the radio is doing in the guild
+func (b *Bot) handleStatus(s *discordgo.Session, m *discordgo.MessageCreate) {
+	state := b.radioManager.GetOrCreate(m.GuildID)
+
+	message := "Радио: **не играет**"
+	if state.IsActive() {
+		channel := fmt.Sprintf("`%s`", state.GetChannelID())
+		if c, err := s.State.Channel(state.GetChannelID()); err == nil {
+			channel = fmt.Sprintf("**%s**", c.Name)
+		}
+		message = fmt.Sprintf("Радио: **играет** в канале %s", channel)
+		if state.IsMuted() {
+			message += " (без звука, ожидание слушателей)"
+		} else if state.IsIdle() {
+			message += " (ожидание слушателей)"
+		}
+	}
+
+	if userID := state.GetFollowUserID(); userID != "" {
+		message += fmt.Sprintf("\nСледует за: <@%s>", userID)
+	}
+
+	autoConnect := "выключено"
+	if state.IsAutoConnectEnabled() {
+		autoConnect = "включено"
+	}
+	message += fmt.Sprintf("\nАвто-подключение: **%s**", autoConnect)
+
+	s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Content: message,
+		// Don't ping the followed user
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+}
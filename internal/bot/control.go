@@ -0,0 +1,250 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// controlDump is the state written by `bot ctl dump-state`
+type controlDump struct {
+	StartedAt        time.Time  `json:"started_at"`
+	Uptime           string     `json:"uptime"`
+	GatewayConnected bool       `json:"gateway_connected"`
+	Guilds           []apiGuild `json:"guilds"`
+}
+
+// startControlServer listens on the control socket used by `bot ctl`
+// Access is limited by the socket's file permissions, there is no token
+func (b *Bot) startControlServer() {
+	listener, err := listenControlSocket(b.cfg().ControlSocket)
+	if err != nil {
+		b.logger.WithError(err).Error("Control socket disabled")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", b.handleControlStatus)
+	mux.HandleFunc("/stop", b.handleControlStop)
+	mux.HandleFunc("/reload", b.handleControlReload)
+	mux.HandleFunc("/dump-state", b.handleControlDump)
+	mux.HandleFunc("/crashes", b.handleControlCrashes)
+	mux.HandleFunc("/crash", b.handleControlCrash)
+
+	b.controlServer = &http.Server{
+		Addr:              b.cfg().ControlSocket,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.logger.Infof("Control socket on %s", b.cfg().ControlSocket)
+		if err := b.controlServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			b.logger.WithError(err).Error("Control socket failed")
+		}
+	}()
+}
+
+// listenControlSocket listens on a Unix socket only the bot's user can use
+// A socket left behind by a crashed process is replaced, one of a running process is not
+func listenControlSocket(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another bot is listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale control socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict control socket: %w", err)
+	}
+	return listener, nil
+}
+
+// handleControlStatus writes a summary of the bot and its guilds
+// GET /status
+func (b *Bot) handleControlStatus(w http.ResponseWriter, r *http.Request) {
+	guilds := make([]apiGuild, 0)
+	playing := 0
+	for _, guildID := range b.botGuildIDs() {
+		guild := b.apiGuild(guildID, false)
+		if guild.Active {
+			playing++
+		}
+		guilds = append(guilds, guild)
+	}
+
+	gateway := "connected"
+	if !b.gatewayConnected.Load() {
+		gateway = "disconnected"
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "uptime %v, gateway %s, %d guilds, %d playing\n\n",
+		time.Since(b.startedAt).Round(time.Second), gateway, len(guilds), playing)
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "GUILD\tNAME\tRADIO\tCHANNEL\tLISTENERS\tSTREAM")
+	for _, guild := range guilds {
+		radio, channel, listeners, stream := "off", "-", "-", "-"
+		if guild.Active {
+			radio = "playing"
+			switch {
+			case guild.FailingSince != nil:
+				radio = "failing"
+			case guild.Muted:
+				radio = "muted"
+			case guild.Idle:
+				radio = "idle"
+			}
+			channel = guild.ChannelID
+			if guild.ChannelName != "" {
+				channel = guild.ChannelName
+			}
+			listeners = fmt.Sprint(guild.Listeners)
+		}
+		if guild.Stream != nil {
+			stream = guild.Stream.Health
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", guild.ID, guild.Name, radio, channel, listeners, stream)
+	}
+	table.Flush()
+}
+
+// handleControlStop stops the radio in a guild
+// POST /stop?guild=<id>
+func (b *Bot) handleControlStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	guildID := r.URL.Query().Get("guild")
+	if _, err := b.session.State.Guild(guildID); err != nil {
+		http.Error(w, fmt.Sprintf("guild %q not found", guildID), http.StatusNotFound)
+		return
+	}
+
+	b.log(guildID).Info("Stop requested over the control socket")
+	if !b.stopRadio(guildID) {
+		fmt.Fprintln(w, "radio stopped, the bot was not in a voice channel")
+		return
+	}
+	fmt.Fprintln(w, "radio stopped")
+}
+
+// handleControlReload reads the configuration and the saved guild settings again, like SIGHUP
+// POST /reload
+func (b *Bot) handleControlReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b.logger.Info("Reload requested over the control socket")
+	result, err := b.reload()
+	if err != nil {
+		b.logger.WithError(err).Error("Failed to reload configuration")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(result.Applied) == 0 && len(result.Restart) == 0 {
+		fmt.Fprintln(w, "configuration unchanged")
+	}
+	if len(result.Applied) > 0 {
+		fmt.Fprintf(w, "applied: %s\n", strings.Join(result.Applied, ", "))
+	}
+	if len(result.Restart) > 0 {
+		fmt.Fprintf(w, "need a restart to take effect: %s\n", strings.Join(result.Restart, ", "))
+	}
+	fmt.Fprintf(w, "reloaded settings of %d guilds\n", result.Guilds)
+}
+
+// handleControlDump writes the full state of the bot as JSON
+// GET /dump-state
+func (b *Bot) handleControlDump(w http.ResponseWriter, r *http.Request) {
+	dump := controlDump{
+		StartedAt:        b.startedAt,
+		Uptime:           time.Since(b.startedAt).Round(time.Second).String(),
+		GatewayConnected: b.gatewayConnected.Load(),
+		Guilds:           make([]apiGuild, 0),
+	}
+	for _, guildID := range b.botGuildIDs() {
+		dump.Guilds = append(dump.Guilds, b.apiGuild(guildID, true))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(dump)
+}
+
+// handleControlCrashes lists the saved crash reports, newest first
+// GET /crashes
+func (b *Bot) handleControlCrashes(w http.ResponseWriter, r *http.Request) {
+	summaries, err := crashSummaries(crashReportDir(b.cfg()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(summaries) == 0 {
+		fmt.Fprintln(w, "no crash reports")
+		return
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tTIME\tGUILD\tTASK\tPANIC")
+	for _, summary := range summaries {
+		at, guild, task := "-", "-", "-"
+		if !summary.Time.IsZero() {
+			at = summary.Time.Local().Format(time.DateTime)
+		}
+		if summary.GuildID != "" {
+			guild = summary.GuildID
+		}
+		if summary.Task != "" {
+			task = summary.Task
+		}
+		panicLine, _, _ := strings.Cut(summary.Panic, "\n")
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", summary.ID, at, guild, task, panicLine)
+	}
+	table.Flush()
+}
+
+// handleControlCrash writes a saved crash report as JSON
+// GET /crash?id=<id>
+func (b *Bot) handleControlCrash(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	report, err := readCrashReport(crashReportDir(b.cfg()), id)
+	if errors.Is(err, errCrashReportNotFound) {
+		http.Error(w, fmt.Sprintf("crash report %q not found, see bot ctl crashes", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+}
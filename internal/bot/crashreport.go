@@ -0,0 +1,246 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ankogit/4duk-discord-bot/internal/config"
+)
+
+// Version of the build, set with -ldflags "-X github.com/ankogit/4duk-discord-bot/internal/bot.Version=..."
+var Version = "dev"
+
+// crashReportTimeFormat names reports, so they sort by time
+const crashReportTimeFormat = "20060102-150405.000000000"
+
+// maxGoroutineDump limits the goroutine dump of a crash report
+const maxGoroutineDump = 8 << 20
+
+// crashReportID matches the ID of a crash report, anything else isn't read from disk
+var crashReportID = regexp.MustCompile(`^\d{8}-\d{6}\.\d{9}$`)
+
+// errCrashReportNotFound is returned for an unknown crash report ID
+var errCrashReportNotFound = errors.New("crash report not found")
+
+// crashReport is a recovered panic written to disk for post-mortem
+type crashReport struct {
+	ID         string                     `json:"id"`
+	Time       time.Time                  `json:"time"`
+	GuildID    string                     `json:"guild_id,omitempty"` // Empty outside guild work
+	Task       string                     `json:"task"`
+	Panic      string                     `json:"panic"`
+	Stack      string                     `json:"stack"`
+	Goroutines string                     `json:"goroutines"`
+	Uptime     string                     `json:"uptime"`
+	Build      buildInfo                  `json:"build"`
+	Config     []config.Setting           `json:"config"`           // Secrets masked
+	Events     map[string][]activityEvent `json:"events,omitempty"` // Recent activity by guild, oldest first
+}
+
+// crashSummary is a crash report as listed by `bot ctl crashes`
+type crashSummary struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	GuildID string    `json:"guild_id,omitempty"`
+	Task    string    `json:"task"`
+	Panic   string    `json:"panic"`
+}
+
+// buildInfo identifies the binary that crashed
+type buildInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"` // Only known when built from a git checkout
+	Modified  bool   `json:"modified,omitempty"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Revision = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// ReportCrash saves a crash report for a panic recovered outside the bot's own supervisor
+func (b *Bot) ReportCrash(task string, panicValue interface{}, stack []byte) {
+	b.reportCrash("", task, panicValue, stack)
+}
+
+// reportCrash saves a crash report of a recovered panic and returns its ID
+// Returns an empty ID if reports are turned off or it couldn't be written
+func (b *Bot) reportCrash(guildID, task string, panicValue interface{}, stack []byte) string {
+	cfg := b.cfg()
+	if cfg.CrashReports == 0 {
+		return ""
+	}
+
+	now := time.Now().UTC()
+	report := &crashReport{
+		ID:         now.Format(crashReportTimeFormat),
+		Time:       now,
+		GuildID:    guildID,
+		Task:       task,
+		Panic:      fmt.Sprint(panicValue),
+		Stack:      string(stack),
+		Goroutines: goroutineDump(),
+		Uptime:     time.Since(b.startedAt).Round(time.Second).String(),
+		Build:      currentBuildInfo(),
+		Config:     cfg.Settings(),
+	}
+	if guildID != "" {
+		report.Events = map[string][]activityEvent{guildID: b.activity.Recent(guildID)}
+	} else {
+		report.Events = b.activity.All()
+	}
+
+	dir := crashReportDir(cfg)
+	if err := writeCrashReport(dir, report); err != nil {
+		b.log(guildID).WithError(err).Error("Failed to write crash report")
+		return ""
+	}
+	if err := pruneCrashReports(dir, cfg.CrashReports); err != nil {
+		b.log(guildID).WithError(err).Warn("Failed to remove old crash reports")
+	}
+	return report.ID
+}
+
+// crashReportDir is where crash reports are kept
+func crashReportDir(cfg *config.Config) string {
+	return filepath.Join(cfg.DataDir, "crashes")
+}
+
+// goroutineDump returns the stacks of all goroutines
+func goroutineDump() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxGoroutineDump {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// writeCrashReport writes a report as <ID>.json, readable only by the bot's user:
+// the dump may contain message contents and user IDs
+func writeCrashReport(dir string, report *crashReport) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create crash report directory: %w", err)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode crash report: %w", err)
+	}
+	path := filepath.Join(dir, report.ID+".json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write crash report: %w", err)
+	}
+	return nil
+}
+
+// crashReportIDs returns the IDs of the saved reports, oldest first
+func crashReportIDs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list crash reports: %w", err)
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if ok && crashReportID.MatchString(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// pruneCrashReports removes the oldest reports beyond keep
+func pruneCrashReports(dir string, keep int) error {
+	ids, err := crashReportIDs(dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for len(ids) > keep {
+		if err := os.Remove(filepath.Join(dir, ids[0]+".json")); err != nil {
+			errs = append(errs, err)
+		}
+		ids = ids[1:]
+	}
+	return errors.Join(errs...)
+}
+
+// readCrashReport reads a saved report by ID
+func readCrashReport(dir, id string) (*crashReport, error) {
+	if !crashReportID.MatchString(id) {
+		return nil, errCrashReportNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errCrashReportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read crash report: %w", err)
+	}
+
+	var report crashReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid crash report %s: %w", id, err)
+	}
+	return &report, nil
+}
+
+// crashSummaries lists the saved reports, newest first
+// Unreadable reports are listed with their error as the panic
+func crashSummaries(dir string) ([]crashSummary, error) {
+	ids, err := crashReportIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]crashSummary, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		report, err := readCrashReport(dir, ids[i])
+		if err != nil {
+			summaries = append(summaries, crashSummary{ID: ids[i], Panic: err.Error()})
+			continue
+		}
+		summaries = append(summaries, crashSummary{
+			ID:      report.ID,
+			Time:    report.Time,
+			GuildID: report.GuildID,
+			Task:    report.Task,
+			Panic:   report.Panic,
+		})
+	}
+	return summaries, nil
+}
@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// dashboardRefresh is how often the dashboard receives the state of all guilds
+	dashboardRefresh = 2 * time.Second
+	// dashboardEvents is how many recent events of each guild the dashboard shows
+	dashboardEvents = 10
+	// adminCookie holds the admin token for the dashboard, EventSource can't send headers
+	adminCookie = "admin_token"
+)
+
+//go:embed dashboard/index.html
+var dashboardHTML []byte
+
+// apiChannel is a voice channel the radio can play in
+type apiChannel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// handleDashboard serves the dashboard page
+// The page itself holds no data, it asks for the token before calling the API
+func (b *Bot) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(dashboardHTML)
+}
+
+// handleAPILogin stores the admin token in a cookie for the dashboard
+// POST /api/login {"token": "..."}
+func (b *Bot) handleAPILogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(b.cfg().AdminToken)) != 1 {
+		b.logger.WithField("remote", r.RemoteAddr).Warn("Failed dashboard login")
+		writeAPIError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminCookie,
+		Value:    req.Token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || !isLoopbackAddr(b.cfg().AdminAddr),
+		SameSite: http.SameSiteStrictMode,
+	})
+	b.logger.WithField("remote", r.RemoteAddr).Info("Dashboard login")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isLoopbackAddr reports whether a listen address only accepts local connections
+// The token cookie may travel in the clear only there
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleAPIEvents streams the state of all guilds and their activity as Server-Sent Events
+// GET /api/events
+//
+//	event: guilds    all guilds with their recent activity, sent every dashboardRefresh
+//	event: activity  a new event in a guild
+func (b *Bot) handleAPIEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	events, unsubscribe := b.activity.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+
+	if err := writeSSE(w, "guilds", b.dashboardGuilds()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-b.ctx.Done():
+			return
+		case event := <-events:
+			err = writeSSE(w, "activity", event)
+		case <-ticker.C:
+			err = writeSSE(w, "guilds", b.dashboardGuilds())
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// dashboardGuilds describes all guilds with their latest activity
+func (b *Bot) dashboardGuilds() []apiGuild {
+	guildIDs := b.botGuildIDs()
+	guilds := make([]apiGuild, 0, len(guildIDs))
+	for _, guildID := range guildIDs {
+		guild := b.apiGuild(guildID, false)
+		guild.Events = b.activity.Recent(guildID)
+		if len(guild.Events) > dashboardEvents {
+			guild.Events = guild.Events[len(guild.Events)-dashboardEvents:]
+		}
+		guilds = append(guilds, guild)
+	}
+	return guilds
+}
+
+// voiceChannels lists the voice channels of a guild in display order
+func (b *Bot) voiceChannels(guildID string) []apiChannel {
+	guild, err := b.session.State.Guild(guildID)
+	if err != nil {
+		return nil
+	}
+
+	b.session.State.RLock()
+	var voice []*discordgo.Channel
+	for _, channel := range guild.Channels {
+		if channel.Type == discordgo.ChannelTypeGuildVoice {
+			voice = append(voice, channel)
+		}
+	}
+	b.session.State.RUnlock()
+
+	sort.Slice(voice, func(i, j int) bool {
+		return voice[i].Position < voice[j].Position
+	})
+
+	channels := make([]apiChannel, 0, len(voice))
+	for _, channel := range voice {
+		channels = append(channels, apiChannel{ID: channel.ID, Name: channel.Name})
+	}
+	return channels
+}
+
+// writeSSE writes one Server-Sent Event with a JSON payload
+func writeSSE(w http.ResponseWriter, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -1,9 +1,12 @@
 package bot
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
 )
 
 // onReady handles the ready event
@@ -13,10 +16,54 @@ func (b *Bot) onReady(s *discordgo.Session, event *discordgo.Ready) {
 	// Start voice check loop
 	b.wg.Add(1)
 	go b.voiceCheckLoop()
+
+	b.readyOnce.Do(func() {
+		// Resume sessions that were playing before a restart
+		b.wg.Add(1)
+		go b.restoreSessions()
+
+		// Join auto-channels that were occupied while the bot was offline
+		b.wg.Add(1)
+		go b.checkAutoConnectChannels()
+
+		// Clean up guilds the bot left while it was offline
+		b.wg.Add(1)
+		go b.guildSweepLoop()
+	})
+}
+
+// onConnect handles the gateway connecting
+func (b *Bot) onConnect(s *discordgo.Session, event *discordgo.Connect) {
+	b.gatewayConnected.Store(true)
+	b.metrics.SetGatewayConnected(true)
+}
+
+// onDisconnect handles the gateway disconnecting
+// discordgo reconnects by itself, the bot is restarted if it doesn't within GatewayTimeout
+func (b *Bot) onDisconnect(s *discordgo.Session, event *discordgo.Disconnect) {
+	b.logger.Warn("Gateway disconnected")
+	b.gatewayConnected.Store(false)
+	b.metrics.SetGatewayConnected(false)
+
+	disconnect := b.disconnects.Add(1)
+	timeout := b.cfg().GatewayTimeout
+	time.AfterFunc(timeout, func() {
+		if b.ctx.Err() != nil || b.gatewayConnected.Load() || b.disconnects.Load() != disconnect {
+			return
+		}
+		b.fail(fmt.Errorf("gateway didn't reconnect within %v", timeout))
+	})
 }
 
 // onMessageCreate handles message creation events
 func (b *Bot) onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	// Message payloads carry the author's member, keep the cache fresh with it
+	if m.GuildID != "" && m.Member != nil && m.Author != nil {
+		member := *m.Member
+		member.User = m.Author
+		b.members.put(m.GuildID, &member)
+	}
+
 	// Ignore messages from bots
 	if m.Author.Bot {
 		return
@@ -45,13 +92,35 @@ func (b *Bot) onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate)
 		b.handleStop(s, m)
 	case "setchannel":
 		b.handleSetChannel(s, m)
+	case "autochannel":
+		b.handleAutoChannel(s, m)
+	case "autorules":
+		b.handleAutoRules(s, m)
 	case "autoconnect":
 		b.handleAutoConnect(s, m)
+	case "idle":
+		b.handleIdle(s, m)
+	case "station":
+		b.handleStation(s, m)
+	case "volume":
+		b.handleVolume(s, m)
+	case "listeners":
+		b.handleListeners(s, m)
+	case "follow":
+		b.handleFollow(s, m)
+	case "unfollow":
+		b.handleUnfollow(s, m)
+	case "status":
+		b.handleStatus(s, m)
+	case "config":
+		b.handleConfig(s, m)
+	case "debug":
+		b.handleDebug(s, m)
 	}
 }
 
 // onVoiceStateUpdate handles voice state updates
-// Triggers auto-connect immediately when a user joins the configured channel
+// Triggers auto-connect immediately when a user joins one of the configured channels
 func (b *Bot) onVoiceStateUpdate(s *discordgo.Session, vs *discordgo.VoiceStateUpdate) {
 	// Ignore bot's own voice state changes
 	if vs.UserID == s.State.User.ID {
@@ -59,33 +128,45 @@ func (b *Bot) onVoiceStateUpdate(s *discordgo.Session, vs *discordgo.VoiceStateU
 	}
 
 	guildID := vs.GuildID
+
+	// Voice state payloads carry the member, keep the cache fresh with it
+	// Only fresh payloads: the voice states kept in discordgo's state hold the member as of their last update
+	if vs.Member != nil {
+		b.members.put(guildID, vs.Member)
+	}
 	
 	// Get state for this guild
 	state := b.radioManager.GetOrCreate(guildID)
 	
-	// Log all voice state updates for debugging
 	var prevChan, currChan string
 	if vs.BeforeUpdate != nil {
 		prevChan = vs.BeforeUpdate.ChannelID
 	}
 	currChan = vs.ChannelID
-	b.logger.Infof("[%s] Voice state update: user=%s, prev_channel=%s, curr_channel=%s", 
-		guildID, vs.UserID, prevChan, currChan)
+	log := b.log(guildID).WithField("user", vs.UserID)
+	log.WithFields(logrus.Fields{"prev_channel": prevChan, "channel": currChan}).Debug("Voice state update")
 	
+	// A listener came back (or undeafened) while we wait in an empty channel
+	if currChan != "" && b.cancelIdleLeave(guildID, currChan) {
+		return
+	}
+
+	// Follow-me mode takes precedence over auto-channels
+	if b.handleFollowedUser(guildID, vs) {
+		return
+	}
+
 	// Check if auto-connect is enabled
 	if !state.IsAutoConnectEnabled() {
-		b.logger.Debugf("[%s] Auto-connect disabled, ignoring voice state update", guildID)
+		log.Debug("Auto-connect disabled, ignoring voice state update")
 		return
 	}
 
-	autoChannelID := state.GetAutoChannelID()
-	if autoChannelID == "" {
+	if len(state.GetAutoChannels()) == 0 {
 		// No auto-channel configured
-		b.logger.Debugf("[%s] No auto-channel configured, ignoring voice state update", guildID)
+		log.Debug("No auto-channels configured, ignoring voice state update")
 		return
 	}
-	
-	b.logger.Infof("[%s] Auto-channel is %s, checking if user joined it", guildID, autoChannelID)
 
 	// Determine if user joined a channel
 	// User joined if:
@@ -114,222 +195,116 @@ func (b *Bot) onVoiceStateUpdate(s *discordgo.Session, vs *discordgo.VoiceStateU
 		if previousChannelID != "" && currentChannelID != "" && previousChannelID != currentChannelID {
 			userJoinedChannel = true
 		}
+		// User became a listener without moving, e.g. undeafened
+		if previousChannelID != "" && previousChannelID == currentChannelID &&
+			!b.isListener(guildID, vs.BeforeUpdate) && b.isListener(guildID, vs.VoiceState) {
+			userJoinedChannel = true
+		}
 	}
 
-	// Log the result of userJoinedChannel check
-	b.logger.Infof("[%s] userJoinedChannel=%v, currentChannelID=%s, autoChannelID=%s", 
-		guildID, userJoinedChannel, currentChannelID, autoChannelID)
+	log.WithField("channel", currentChannelID).Debugf("userJoinedChannel=%v", userJoinedChannel)
 
-	// Check if user joined the auto-channel
-	if userJoinedChannel && currentChannelID == autoChannelID {
+	// Whatever the user joined, the channel they left may be the one the radio plays in
+	if previousChannelID != "" && previousChannelID != currentChannelID {
+		b.onListenerLeft(guildID, previousChannelID, vs.BeforeUpdate, log)
+	}
+
+	// Check if user joined one of the auto-channels
+	if userJoinedChannel && state.IsAutoChannel(currentChannelID) {
 		channelID := currentChannelID
-		b.logger.Infof("[%s] User joined auto-channel %s, processing...", guildID, channelID)
+		log = log.WithField("channel", channelID)
+		log.Debug("User joined auto-channel, processing...")
 
-		// Get user to check if it's a bot
-		b.logger.Infof("[%s] Getting member info for user %s", guildID, vs.UserID)
-		member, err := s.GuildMember(guildID, vs.UserID)
-		if err != nil {
-			b.logger.WithError(err).Warnf("[%s] Failed to get member info for user %s", guildID, vs.UserID)
-			return
+		userName := vs.UserID
+		if vs.Member != nil && vs.Member.User != nil {
+			userName = vs.Member.User.Username
 		}
-		b.logger.Infof("[%s] Got member info: %s (bot: %v)", guildID, member.User.Username, member.User.Bot)
 
-		// Ignore bots
-		if member.User.Bot {
-			b.logger.Infof("[%s] User %s is a bot, ignoring", guildID, member.User.Username)
+		// Ignore bots, deafened and ignored members
+		if !b.isListener(guildID, vs.VoiceState) {
+			log.Debugf("User %s is not a listener, ignoring", userName)
 			return
 		}
 
-		// Check if bot is already in this channel and active
-		if state.IsActive() {
-			botVS, err := s.State.VoiceState(guildID, s.State.User.ID)
-			if err == nil && botVS != nil && botVS.ChannelID == channelID {
-				// Bot is already in this channel and active
-				b.logger.Infof("[%s] Bot is already in channel %s and active, skipping", guildID, channelID)
-				return
-			}
-		}
-
-		// Get channel info
-		channel, err := s.Channel(channelID)
-		if err != nil {
-			b.logger.WithError(err).Warnf("[%s] Failed to get channel info", guildID)
+		// Only members with a trigger role, within the allowed days and hours
+		if !b.canTriggerAutoConnect(guildID, vs.UserID) {
+			log.Debugf("User %s has no trigger role, ignoring", userName)
 			return
 		}
-		b.logger.Infof("[%s] Got channel info: %s (type: %d)", guildID, channel.Name, channel.Type)
-
-		// Only join voice channels
-		if channel.Type != discordgo.ChannelTypeGuildVoice {
-			b.logger.Warnf("[%s] Channel %s is not a voice channel (type: %d), skipping", guildID, channel.Name, channel.Type)
+		if !b.isAutoConnectTimeAllowed(guildID) {
+			log.Debug("Outside of auto-connect days and hours, ignoring")
 			return
 		}
 
-		// Count users in channel using session state (more up-to-date than guild.VoiceStates)
-		userCount := b.countUsersInChannelFromState(guildID, channelID)
-		b.logger.Infof("[%s] User count in channel %s: %d", guildID, channel.Name, userCount)
-		if userCount == 0 {
-			// If countUsersInChannelFromState returns 0, but we know user just joined,
-			// use the event data directly - at least 1 user (the one who just joined)
-			b.logger.Infof("[%s] User count was 0, but user %s just joined, using count=1", guildID, member.User.Username)
-			userCount = 1
+		// Don't leave the followed user
+		if b.isFollowing(guildID) {
+			log.Debug("Following a user, ignoring auto-channel join")
+			return
 		}
 
-		b.logger.Infof("[%s] User %s joined auto-channel %s (%d users), triggering immediate auto-connect", 
-			guildID, member.User.Username, channel.Name, userCount)
-
-		// Trigger auto-connect immediately in a goroutine
-		b.logger.Infof("[%s] Starting auto-connect goroutine for channel %s", guildID, channelID)
-		b.wg.Add(1)
-		go func(gid, cid string) {
-			defer b.wg.Done()
-			defer func() {
-				if r := recover(); r != nil {
-					b.logger.WithField("panic", r).
-						Errorf("[%s] Panic in auto-connect goroutine", gid)
-				}
-			}()
-
-			b.logger.Infof("[%s] Auto-connect goroutine started", gid)
-			state := b.radioManager.GetOrCreate(gid)
-			
-			// Double-check auto-connect is still enabled and channel matches
-			if !state.IsAutoConnectEnabled() {
-				b.logger.Warnf("[%s] Auto-connect disabled in goroutine, aborting", gid)
-				return
-			}
-			if state.GetAutoChannelID() != cid {
-				b.logger.Warnf("[%s] Auto-channel changed in goroutine (%s != %s), aborting", gid, state.GetAutoChannelID(), cid)
+		// Stay where we are while people are listening, moving only when the current channel empties
+		if state.IsActive() {
+			if current := state.GetChannelID(); current != "" && b.countUsersInChannelFromState(guildID, current) > 0 {
+				log.Debugf("Bot is already playing in channel %s with listeners, skipping", current)
 				return
 			}
+		}
 
-			// Check if already active
-			if state.IsActive() {
-				botVS, err := b.session.State.VoiceState(gid, b.session.State.User.ID)
-				if err == nil && botVS != nil && botVS.ChannelID == cid {
-					b.logger.Infof("[%s] Already connected to channel %s, skipping", gid, cid)
-					return
-				}
-			}
-
-			// Set state
-			b.logger.Infof("[%s] Setting state: active=true, channel=%s", gid, cid)
-			state.SetActive(true)
-			state.SetChannelID(cid)
-			state.ResetReconnectAttempts()
-
-			// Connect to channel
-			b.logger.Infof("[%s] Connecting to channel %s...", gid, cid)
-			vc, err := b.connectToChannel(b.session, gid, cid)
-			if err != nil {
-				b.logger.WithError(err).Errorf("[%s] Failed to auto-connect to channel", gid)
-				state.SetActive(false)
+		target, userCount := b.pickAutoChannel(guildID)
+		if target == "" {
+			// If the state doesn't show the user yet, use the event data directly -
+			// at least 1 user (the one who just joined)
+			if !b.isUsableAutoChannel(guildID, channelID) {
+				log.Info("Auto-channel is the AFK channel or lacks permissions, skipping")
 				return
 			}
-
-			if vc == nil {
-				b.logger.Errorf("[%s] Voice connection is nil after auto-connect", gid)
-				state.SetActive(false)
+			if state.GetAutoConnectRules().RequiredListeners() > 1 {
+				log.Debug("Not enough listeners in auto-channels yet, skipping")
 				return
 			}
-
-			b.logger.Infof("[%s] Successfully connected to channel, starting radio...", gid)
-			// Start radio
-			err = b.startRadio(vc, gid)
-			if err != nil {
-				b.logger.WithError(err).Errorf("[%s] Failed to start radio after auto-connect", gid)
-				state.SetActive(false)
-			} else {
-				b.logger.Infof("[%s] Radio started successfully after auto-connect", gid)
-			}
-		}(guildID, channelID)
-	} else if vs.BeforeUpdate != nil && vs.BeforeUpdate.ChannelID != "" && vs.ChannelID == "" {
-		// User left a channel (was in a channel before, now not in any channel)
-		// Or user moved from one channel to another
-		leftChannelID := vs.BeforeUpdate.ChannelID
-
-		// Check if user left the auto-channel
-		if leftChannelID != autoChannelID {
-			return
+			log.Debugf("User count was 0, but user %s just joined, using count=1", userName)
+			target, userCount = channelID, 1
 		}
 
-		// Get user to check if it's a bot
-		member, err := s.GuildMember(guildID, vs.UserID)
-		if err != nil {
-			b.logger.WithError(err).Debugf("[%s] Failed to get member info", guildID)
-			return
-		}
-
-		// Ignore bots
-		if member.User.Bot {
-			return
-		}
+		log.Infof("User %s joined auto-channel, auto-connecting to %s (%d listeners, rule %s)", userName, target, userCount, state.GetAutoChannelRule())
 
-		// Check if radio is active for this channel
-		if !state.IsActive() || state.GetChannelID() != leftChannelID {
+		b.startAutoConnect(guildID, target, b.cfg().AutoConnectDebounce)
+	} else if vs.BeforeUpdate != nil && vs.ChannelID != "" && vs.BeforeUpdate.ChannelID == vs.ChannelID {
+		// User stayed in the channel but may have stopped listening, e.g. deafened
+		if !state.IsAutoChannel(vs.ChannelID) || !state.IsActive() || state.GetChannelID() != vs.ChannelID {
 			return
 		}
 
-		// Count remaining users in channel (using more up-to-date state)
-		userCount := b.countUsersInChannelFromState(guildID, leftChannelID)
-		b.logger.Infof("[%s] User %s left channel %s, remaining users: %d", guildID, member.User.Username, leftChannelID, userCount)
-		
-		if userCount == 0 {
-			b.logger.Infof("[%s] Last user left channel %s, stopping radio", guildID, leftChannelID)
-			
-			// Stop radio
-			state.SetActive(false)
-			state.ResetReconnectAttempts()
-
-			// Disconnect if connected
-			if vc, exists := s.VoiceConnections[guildID]; exists {
-				delete(s.VoiceConnections, guildID)
-				func() {
-					defer func() { recover() }()
-					_ = vc.Disconnect(b.ctx)
-				}()
-			}
-		} else {
-			b.logger.Infof("[%s] %d users still in channel %s, keeping radio", guildID, userCount, leftChannelID)
-		}
-	} else if vs.BeforeUpdate != nil && vs.BeforeUpdate.ChannelID != "" && vs.ChannelID != "" && vs.BeforeUpdate.ChannelID != vs.ChannelID {
-		// User moved from one channel to another
-		leftChannelID := vs.BeforeUpdate.ChannelID
-
-		// Check if user left the auto-channel
-		if leftChannelID == autoChannelID {
-			// Get user to check if it's a bot
-			member, err := s.GuildMember(guildID, vs.UserID)
-			if err == nil && !member.User.Bot {
-				// Check if radio is active for the channel user left
-				if state.IsActive() && state.GetChannelID() == leftChannelID {
-					// Count remaining users in the channel user left (using more up-to-date state)
-					userCount := b.countUsersInChannelFromState(guildID, leftChannelID)
-					b.logger.Infof("[%s] User %s moved from channel %s, remaining users: %d", guildID, member.User.Username, leftChannelID, userCount)
-					
-					if userCount == 0 {
-						b.logger.Infof("[%s] Last user left channel %s, stopping radio", guildID, leftChannelID)
-						
-						// Stop radio
-						state.SetActive(false)
-						state.ResetReconnectAttempts()
-
-						// Disconnect if connected
-						if vc, exists := s.VoiceConnections[guildID]; exists {
-							delete(s.VoiceConnections, guildID)
-							func() {
-								defer func() { recover() }()
-								_ = vc.Disconnect(b.ctx)
-							}()
-						}
-					} else {
-						b.logger.Infof("[%s] %d users still in channel %s, keeping radio", guildID, userCount, leftChannelID)
-					}
-				}
+		if b.isListener(guildID, vs.BeforeUpdate) && !b.isListener(guildID, vs.VoiceState) {
+			userCount := b.countUsersInChannelFromState(guildID, vs.ChannelID)
+			log.WithField("channel", vs.ChannelID).Infof("User stopped listening, remaining listeners: %d", userCount)
+			if userCount == 0 {
+				b.onAutoChannelEmptied(guildID, vs.ChannelID)
 			}
 		}
-
-		// Check if user joined the auto-channel (handled above in the first condition)
-		// This is already handled by the first if statement, so we don't need to duplicate
 	}
 }
 
+// onListenerLeft leaves or moves the radio when a user left or moved out of the auto-channel it plays in
+func (b *Bot) onListenerLeft(guildID, leftChannelID string, before *discordgo.VoiceState, log *logrus.Entry) {
+	state := b.radioManager.GetOrCreate(guildID)
+	if !state.IsAutoChannel(leftChannelID) || !state.IsActive() || state.GetChannelID() != leftChannelID {
+		return
+	}
+
+	// Ignore bots, deafened and ignored members - they didn't count anyway
+	if !b.isListener(guildID, before) {
+		return
+	}
+
+	// Count remaining users in channel (using more up-to-date state)
+	userCount := b.countUsersInChannelFromState(guildID, leftChannelID)
+	log = log.WithField("channel", leftChannelID)
+	log.Infof("User left channel, remaining users: %d", userCount)
+
+	if userCount == 0 {
+		b.onAutoChannelEmptied(guildID, leftChannelID)
+	} else {
+		log.Debugf("%d users still in channel, keeping radio", userCount)
+	}
+}
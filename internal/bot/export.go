@@ -0,0 +1,390 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/ankogit/4duk-discord-bot/internal/config"
+	"github.com/ankogit/4duk-discord-bot/internal/radio"
+)
+
+const (
+	// maxImportSize limits the size of an imported settings file
+	maxImportSize = 1 << 20
+	// importConfirmTimeout is how long an import waits for confirmation
+	importConfirmTimeout = 5 * time.Minute
+	// maxDiffValueLength shortens long values in the import diff
+	maxDiffValueLength = 150
+	// maxMessageLength keeps messages below Discord's limit
+	maxMessageLength = 1900
+)
+
+// pendingImport is an import waiting for the admin's confirmation
+type pendingImport struct {
+	userID    string
+	config    radio.GuildConfig
+	expiresAt time.Time
+}
+
+// handleConfig handles the !config command
+// Exports and imports the guild's settings as a JSON file
+func (b *Bot) handleConfig(s *discordgo.Session, m *discordgo.MessageCreate) {
+	textChannelID := m.ChannelID
+
+	usage := "Использование:\n" +
+		"`!config export` — выгрузить настройки сервера в файл\n" +
+		"`!config import` с приложенным файлом — загрузить настройки\n" +
+		"`!config confirm` или `!config cancel` — применить или отменить загрузку"
+
+	if !b.isGuildAdmin(s, m) {
+		s.ChannelMessageSend(textChannelID, "Эта команда доступна только администраторам сервера (право **Управлять сервером**).")
+		return
+	}
+
+	parts := strings.Fields(m.Content)
+	if len(parts) < 2 {
+		s.ChannelMessageSend(textChannelID, usage)
+		return
+	}
+
+	switch strings.ToLower(parts[1]) {
+	case "export":
+		b.exportConfig(s, m)
+	case "import":
+		b.importConfig(s, m)
+	case "confirm":
+		b.confirmImport(s, m)
+	case "cancel":
+		b.importsMu.Lock()
+		_, exists := b.pendingImports[m.GuildID]
+		delete(b.pendingImports, m.GuildID)
+		b.importsMu.Unlock()
+		if !exists {
+			s.ChannelMessageSend(textChannelID, "Нет загрузки, ожидающей подтверждения.")
+			return
+		}
+		s.ChannelMessageSend(textChannelID, "Загрузка настроек отменена.")
+	default:
+		s.ChannelMessageSend(textChannelID, usage)
+	}
+}
+
+// exportConfig sends the guild's settings as a JSON attachment
+// A station with credentials is left out, the file is posted to a channel others may read
+func (b *Bot) exportConfig(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := m.GuildID
+	guildConfig := b.radioManager.ExportGuild(guildID)
+
+	content := "📦 Настройки сервера. Загрузите файл на другом сервере командой `!config import`."
+	if guildConfig.Station != config.RedactURL(guildConfig.Station) {
+		guildConfig.Station = ""
+		content += "\n⚠️ Адрес станции содержит логин, пароль или параметры доступа и не выгружен — задайте его на другом сервере командой `!station`."
+	}
+
+	export := radio.GuildExport{
+		Version:  radio.SchemaVersion,
+		GuildID:  guildID,
+		Channels: make(map[string]string),
+		Roles:    make(map[string]string),
+		Config:   guildConfig,
+	}
+
+	// Names let the import find the same channels and roles on another server
+	for _, channelID := range guildConfig.ChannelIDs() {
+		if channel, err := s.State.Channel(channelID); err == nil {
+			export.Channels[channelID] = channel.Name
+		}
+	}
+	for _, roleID := range guildConfig.RoleIDs() {
+		if role, err := s.State.Role(guildID, roleID); err == nil {
+			export.Roles[roleID] = role.Name
+		}
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		b.log(guildID).WithError(err).Error("Failed to encode settings export")
+		s.ChannelMessageSend(m.ChannelID, "Не удалось выгрузить настройки.")
+		return
+	}
+
+	_, err = s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Content: content,
+		Files: []*discordgo.File{{
+			Name:        fmt.Sprintf("radio-config-%s.json", guildID),
+			ContentType: "application/json",
+			Reader:      bytes.NewReader(data),
+		}},
+	})
+	if err != nil {
+		b.log(guildID).WithError(err).Error("Failed to send settings export")
+		return
+	}
+	b.log(guildID).Infof("Settings exported by %s", m.Author.ID)
+}
+
+// importConfig validates an attached settings file and shows what it would change
+func (b *Bot) importConfig(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := m.GuildID
+	textChannelID := m.ChannelID
+
+	if len(m.Attachments) == 0 {
+		s.ChannelMessageSend(textChannelID, "Приложите к команде файл, выгруженный через `!config export`.")
+		return
+	}
+
+	data, err := b.downloadAttachment(m.Attachments[0])
+	if err != nil {
+		b.log(guildID).WithError(err).Warn("Failed to download settings file")
+		s.ChannelMessageSend(textChannelID, fmt.Sprintf("Не удалось скачать файл: %v", err))
+		return
+	}
+
+	export, err := radio.ParseGuildExport(data)
+	if err != nil {
+		s.ChannelMessageSend(textChannelID, fmt.Sprintf("Файл не подходит: %v", err))
+		return
+	}
+
+	config, remapped, missing, err := b.resolveImport(guildID, export)
+	if err != nil {
+		b.log(guildID).WithError(err).Warn("Failed to check imported settings")
+		s.ChannelMessageSend(textChannelID, "Не удалось проверить настройки на этом сервере, попробуйте позже.")
+		return
+	}
+	if len(missing) > 0 {
+		s.ChannelMessageSend(textChannelID, truncateMessage("На этом сервере не найдены:\n• "+strings.Join(missing, "\n• ")+
+			"\n\nСоздайте их или исправьте файл и загрузите снова."))
+		return
+	}
+
+	changes, err := radio.DiffGuildConfigs(b.radioManager.ExportGuild(guildID), config)
+	if err != nil {
+		b.log(guildID).WithError(err).Error("Failed to compare settings")
+		s.ChannelMessageSend(textChannelID, "Не удалось сравнить настройки.")
+		return
+	}
+	if len(changes) == 0 {
+		s.ChannelMessageSend(textChannelID, "Настройки в файле совпадают с текущими, менять нечего.")
+		return
+	}
+
+	b.importsMu.Lock()
+	b.pendingImports[guildID] = &pendingImport{
+		userID:    m.Author.ID,
+		config:    config,
+		expiresAt: time.Now().Add(importConfirmTimeout),
+	}
+	b.importsMu.Unlock()
+
+	message := "Будут изменены настройки:\n"
+	for _, change := range changes {
+		change = redactStationChange(change)
+		message += fmt.Sprintf("• `%s`: `%s` → `%s`\n", change.Field,
+			shortenValue(change.Old), shortenValue(change.New))
+	}
+	if len(remapped) > 0 {
+		message += "\nСопоставлено по названию:\n• " + strings.Join(remapped, "\n• ") + "\n"
+	}
+	message += fmt.Sprintf("\nПрименить: `!config confirm`, отменить: `!config cancel` (в течение %v)", importConfirmTimeout)
+
+	s.ChannelMessageSendComplex(textChannelID, &discordgo.MessageSend{
+		Content: truncateMessage(message),
+		// Don't ping the users and roles in the diff
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+}
+
+// confirmImport applies the import waiting for confirmation
+func (b *Bot) confirmImport(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := m.GuildID
+	textChannelID := m.ChannelID
+
+	b.importsMu.Lock()
+	pending, exists := b.pendingImports[guildID]
+	if exists && pending.userID == m.Author.ID {
+		delete(b.pendingImports, guildID)
+	}
+	b.importsMu.Unlock()
+
+	if !exists || time.Now().After(pending.expiresAt) {
+		s.ChannelMessageSend(textChannelID, "Нет загрузки, ожидающей подтверждения. Загрузите файл заново: `!config import`.")
+		return
+	}
+	if pending.userID != m.Author.ID {
+		s.ChannelMessageSend(textChannelID, "Подтвердить загрузку может только тот, кто её начал.")
+		return
+	}
+
+	if err := b.radioManager.ImportGuild(guildID, pending.config); err != nil {
+		b.log(guildID).WithError(err).Error("Failed to save imported settings")
+		s.ChannelMessageSend(textChannelID, "⚠️ Настройки применены, но не сохранились и пропадут после перезапуска бота.")
+		return
+	}
+
+	// Skips channels the bot has no permissions in
+	b.validateAutoChannels(guildID)
+
+	b.log(guildID).Infof("Settings imported by %s", m.Author.ID)
+	s.ChannelMessageSend(textChannelID, "✅ Настройки загружены")
+}
+
+// resolveImport maps the channels and roles of an export to a guild
+// IDs missing from the guild are matched by name; returns the matched and missing references
+func (b *Bot) resolveImport(guildID string, export *radio.GuildExport) (radio.GuildConfig, []string, []string, error) {
+	guild, err := b.session.State.Guild(guildID)
+	if err != nil {
+		return radio.GuildConfig{}, nil, nil, err
+	}
+
+	var remapped, missing []string
+	channels := make(map[string]string)
+	roles := make(map[string]string)
+
+	for _, channelID := range export.Config.ChannelIDs() {
+		if channel, err := b.session.State.Channel(channelID); err == nil && channel.GuildID == guildID {
+			continue
+		}
+		name := export.Channels[channelID]
+		if match := findVoiceChannel(guild, name); match != nil {
+			channels[channelID] = match.ID
+			remapped = append(remapped, fmt.Sprintf("канал **%s**", name))
+			continue
+		}
+		missing = append(missing, describeReference("голосовой канал", channelID, name))
+	}
+
+	for _, roleID := range export.Config.RoleIDs() {
+		if _, err := b.session.State.Role(guildID, roleID); err == nil {
+			continue
+		}
+		name := export.Roles[roleID]
+		if match := findRole(guild, name); match != nil {
+			roles[roleID] = match.ID
+			remapped = append(remapped, fmt.Sprintf("роль **%s**", name))
+			continue
+		}
+		missing = append(missing, describeReference("роль", roleID, name))
+	}
+
+	return export.Config.WithIDs(channels, roles), remapped, missing, nil
+}
+
+// findVoiceChannel finds a guild's voice channel by name
+func findVoiceChannel(guild *discordgo.Guild, name string) *discordgo.Channel {
+	if name == "" {
+		return nil
+	}
+	for _, channel := range guild.Channels {
+		if channel.Type == discordgo.ChannelTypeGuildVoice && channel.Name == name {
+			return channel
+		}
+	}
+	return nil
+}
+
+// findRole finds a guild's role by name
+func findRole(guild *discordgo.Guild, name string) *discordgo.Role {
+	if name == "" {
+		return nil
+	}
+	for _, role := range guild.Roles {
+		if role.Name == name {
+			return role
+		}
+	}
+	return nil
+}
+
+// describeReference names a channel or role from an export for the user
+func describeReference(kind, id, name string) string {
+	if name == "" {
+		return fmt.Sprintf("%s `%s`", kind, id)
+	}
+	return fmt.Sprintf("%s **%s** (`%s`)", kind, name, id)
+}
+
+// downloadAttachment downloads a small attachment
+func (b *Bot) downloadAttachment(attachment *discordgo.MessageAttachment) ([]byte, error) {
+	if attachment.Size > maxImportSize {
+		return nil, fmt.Errorf("файл больше %d КБ", maxImportSize/1024)
+	}
+
+	ctx, cancel := context.WithTimeout(b.ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxImportSize))
+}
+
+// isGuildAdmin reports whether the author of a message may manage the guild
+func (b *Bot) isGuildAdmin(s *discordgo.Session, m *discordgo.MessageCreate) bool {
+	perms, err := s.State.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	if err != nil {
+		// The member may be missing from state
+		perms, err = s.UserChannelPermissions(m.Author.ID, m.ChannelID)
+		if err != nil {
+			b.log(m.GuildID).WithError(err).Debugf("Failed to compute permissions of %s", m.Author.ID)
+			return false
+		}
+	}
+	return perms&(discordgo.PermissionManageGuild|discordgo.PermissionAdministrator) != 0
+}
+
+// shortenValue shortens a long value for display
+func shortenValue(value string) string {
+	if value == "" {
+		return "—"
+	}
+	if len([]rune(value)) > maxDiffValueLength {
+		return string([]rune(value)[:maxDiffValueLength]) + "…"
+	}
+	return value
+}
+
+// redactStationChange masks the credentials of the station in a settings change, see config.RedactURL
+func redactStationChange(change radio.ConfigChange) radio.ConfigChange {
+	if change.Field != "station" {
+		return change
+	}
+	for _, value := range []*string{&change.Old, &change.New} {
+		var station string
+		if err := json.Unmarshal([]byte(*value), &station); err != nil {
+			continue
+		}
+		if redacted, err := json.Marshal(config.RedactURL(station)); err == nil {
+			*value = string(redacted)
+		}
+	}
+	return change
+}
+
+// truncateMessage keeps a message below Discord's length limit
+func truncateMessage(message string) string {
+	if len([]rune(message)) <= maxMessageLength {
+		return message
+	}
+	return string([]rune(message)[:maxMessageLength]) + "…"
+}
@@ -0,0 +1,170 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// handleFollowedUser keeps the radio with the followed user as they move between voice channels
+// Returns true if the voice state belongs to the followed user and was handled
+func (b *Bot) handleFollowedUser(guildID string, vs *discordgo.VoiceStateUpdate) bool {
+	state := b.radioManager.GetOrCreate(guildID)
+	if state.GetFollowUserID() == "" || state.GetFollowUserID() != vs.UserID {
+		return false
+	}
+
+	var prevChan string
+	if vs.BeforeUpdate != nil {
+		prevChan = vs.BeforeUpdate.ChannelID
+	}
+	currChan := vs.ChannelID
+
+	// Mute or deafen changes, nothing to follow
+	if prevChan == currChan {
+		return true
+	}
+
+	// Disconnected (or went AFK) - pause and leave if they don't come back in time
+	if currChan == "" || b.isAFKChannel(guildID, currChan) {
+		if state.IsActive() {
+			b.log(guildID).Infof("Followed user %s left voice, pausing for %v", vs.UserID, b.cfg().FollowTimeout)
+			b.scheduleLeave(guildID, state.GetChannelID(), b.cfg().FollowTimeout, true)
+		}
+		return true
+	}
+
+	if state.IsActive() && state.GetChannelID() == currChan {
+		if state.StopIdle() {
+			b.log(guildID).Infof("Followed user %s is back in channel %s, resuming", vs.UserID, currChan)
+		}
+		return true
+	}
+
+	b.log(guildID).Infof("Followed user %s moved to channel %s, following", vs.UserID, currChan)
+	b.startFollowConnect(guildID, currChan)
+	return true
+}
+
+// isFollowing reports whether follow-me mode is on and the followed user is in a voice channel
+// While it is, auto-channels don't move the radio away
+func (b *Bot) isFollowing(guildID string) bool {
+	userID := b.radioManager.GetOrCreate(guildID).GetFollowUserID()
+	if userID == "" {
+		return false
+	}
+	vs, err := b.session.State.VoiceState(guildID, userID)
+	return err == nil && vs != nil && vs.ChannelID != ""
+}
+
+// startFollowConnect moves the radio to the followed user's channel in the background
+func (b *Bot) startFollowConnect(guildID, channelID string) {
+	b.goGuild(guildID, "follow", func() {
+		err := b.followConnect(guildID, channelID)
+		if errors.Is(err, errConnectInProgress) {
+			b.log(guildID).WithFields(logrus.Fields{"user": b.radioManager.GetOrCreate(guildID).GetFollowUserID(), "channel": channelID}).
+				Warn("Connect already in progress, not following the user into the channel")
+		} else if err != nil {
+			b.log(guildID).WithError(err).Errorf("Failed to follow into channel %s", channelID)
+		}
+	})
+}
+
+// errConnectInProgress is returned when another connect of the guild is running
+var errConnectInProgress = errors.New("connect already in progress")
+
+// followConnect moves the radio to the followed user's channel
+func (b *Bot) followConnect(guildID, channelID string) error {
+	state := b.radioManager.GetOrCreate(guildID)
+
+	if !state.BeginConnect() {
+		return errConnectInProgress
+	}
+	defer state.EndConnect()
+
+	if state.GetFollowUserID() == "" {
+		return nil
+	}
+
+	if err := b.checkVoiceJoin(guildID, channelID); err != nil {
+		return err
+	}
+	return b.connectAndPlay(guildID, channelID, state.GetFollowUserID())
+}
+
+// handleFollow handles the !follow command
+// Makes the radio follow a user between voice channels
+func (b *Bot) handleFollow(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := m.GuildID
+	textChannelID := m.ChannelID
+
+	parts := strings.Fields(m.Content)
+	if len(parts) < 2 {
+		s.ChannelMessageSend(textChannelID, "Использование: `!follow <@пользователь>`")
+		return
+	}
+
+	userID := parseMentionID(parts[1])
+	member, err := s.GuildMember(guildID, userID)
+	if err != nil || member.User == nil {
+		s.ChannelMessageSend(textChannelID, "Пользователь не найден на сервере.")
+		return
+	}
+
+	if member.User.Bot {
+		s.ChannelMessageSend(textChannelID, "Следовать за ботами нельзя.")
+		return
+	}
+
+	state := b.radioManager.GetOrCreate(guildID)
+	state.SetFollowUserID(userID)
+	if !b.saveCommandState(s, textChannelID, guildID) {
+		return
+	}
+	b.log(guildID).Infof("Following user %s", userID)
+
+	vs, err := s.State.VoiceState(guildID, userID)
+	if err != nil || vs == nil || vs.ChannelID == "" {
+		s.ChannelMessageSend(textChannelID, fmt.Sprintf("👣 Радио будет следовать за **%s**, как только он(а) зайдёт в голосовой канал.", member.User.Username))
+		return
+	}
+
+	// Join before answering, so a failed join is answered with its reason
+	if !state.IsActive() || state.GetChannelID() != vs.ChannelID {
+		err := b.followConnect(guildID, vs.ChannelID)
+		if errors.Is(err, errConnectInProgress) {
+			b.log(guildID).WithFields(logrus.Fields{"user": userID, "channel": vs.ChannelID}).
+				Warn("Connect already in progress, not following the user into the channel")
+			s.ChannelMessageSend(textChannelID, fmt.Sprintf("👣 Радио следует за **%s**, но бот уже подключается к другому каналу — перейдёт за ним при следующей смене канала.", member.User.Username))
+			return
+		}
+		if err != nil {
+			b.log(guildID).WithError(err).Errorf("Failed to follow into channel %s", vs.ChannelID)
+			s.ChannelMessageSend(textChannelID, joinFailureMessage(err, "Не удалось подключиться к голосовому каналу."))
+			return
+		}
+	}
+	s.ChannelMessageSend(textChannelID, fmt.Sprintf("👣 Радио следует за **%s**", member.User.Username))
+}
+
+// handleUnfollow handles the !unfollow command
+func (b *Bot) handleUnfollow(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := m.GuildID
+	state := b.radioManager.GetOrCreate(guildID)
+
+	if state.GetFollowUserID() == "" {
+		s.ChannelMessageSend(m.ChannelID, "Радио ни за кем не следует.")
+		return
+	}
+
+	state.SetFollowUserID("")
+	if !b.saveCommandState(s, m.ChannelID, guildID) {
+		return
+	}
+	b.log(guildID).Info("Stopped following")
+
+	s.ChannelMessageSend(m.ChannelID, "Радио больше ни за кем не следует.")
+}
@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/ankogit/4duk-discord-bot/internal/radio"
+)
+
+// healthCheck is the result of one readiness check
+type healthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// healthResponse is the JSON body of the health endpoints
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Uptime string                 `json:"uptime"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+// handleHealthz reports whether the process loop is alive
+func (b *Bot) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, b.startedAt, map[string]healthCheck{
+		"loop": b.checkLoop(),
+	})
+}
+
+// handleReadyz reports whether the bot can do its job
+func (b *Bot) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, b.startedAt, map[string]healthCheck{
+		"loop":    b.checkLoop(),
+		"gateway": b.checkGateway(),
+		"storage": b.checkStorage(),
+		"ffmpeg":  checkFFmpeg(),
+		"guilds":  b.checkGuilds(),
+	})
+}
+
+// writeHealth writes the checks as JSON, with 503 if any failed
+func writeHealth(w http.ResponseWriter, startedAt time.Time, checks map[string]healthCheck) {
+	response := healthResponse{
+		Status: "ok",
+		Uptime: time.Since(startedAt).Round(time.Second).String(),
+		Checks: checks,
+	}
+	status := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			response.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// checkLoop checks that the voice check loop ticked recently
+// Before the first Ready the loop isn't running yet, so the start time counts instead
+func (b *Bot) checkLoop() healthCheck {
+	last := b.startedAt
+	if beat := b.heartbeat.Load(); beat != 0 {
+		last = time.Unix(0, beat)
+	}
+
+	// A few missed ticks mean the loop is stuck
+	limit := 3 * b.cfg().VoiceCheckInterval
+	if since := time.Since(last); since > limit {
+		return healthCheck{Detail: fmt.Sprintf("voice check loop last ran %v ago", since.Round(time.Second))}
+	}
+	return healthCheck{OK: true}
+}
+
+// checkGateway checks the Discord gateway connection
+func (b *Bot) checkGateway() healthCheck {
+	if !b.gatewayConnected.Load() {
+		return healthCheck{Detail: "gateway disconnected"}
+	}
+	return healthCheck{OK: true}
+}
+
+// checkStorage checks that the data directory is writable
+func (b *Bot) checkStorage() healthCheck {
+	if b.cfg().StorageBackend == radio.StorageMemory {
+		return healthCheck{OK: true, Detail: "in memory"}
+	}
+
+	probe, err := os.CreateTemp(b.cfg().DataDir, ".healthcheck-*")
+	if err != nil {
+		return healthCheck{Detail: err.Error()}
+	}
+	probe.Close()
+	_ = os.Remove(probe.Name())
+	return healthCheck{OK: true}
+}
+
+// checkFFmpeg checks that ffmpeg is installed
+func checkFFmpeg() healthCheck {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return healthCheck{Detail: err.Error()}
+	}
+	return healthCheck{OK: true, Detail: path}
+}
+
+// checkGuilds checks that no guild stays without a voice connection for too long
+func (b *Bot) checkGuilds() healthCheck {
+	var failing []string
+	for _, guildID := range b.radioManager.GetAllGuildIDs() {
+		state, exists := b.radioManager.Get(guildID)
+		if !exists || !state.IsActive() {
+			continue
+		}
+		if since := state.FailingSince(); !since.IsZero() && time.Since(since) > b.cfg().FailedGuildThreshold {
+			failing = append(failing, guildID)
+		}
+	}
+
+	if len(failing) > 0 {
+		return healthCheck{Detail: fmt.Sprintf("guilds without voice for over %v: %v", b.cfg().FailedGuildThreshold, failing)}
+	}
+	return healthCheck{OK: true}
+}
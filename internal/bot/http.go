@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// startHTTPServer starts the HTTP listener for metrics and health checks if an address is configured
+func (b *Bot) startHTTPServer() {
+	if b.cfg().HTTPAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", b.metrics.Handler())
+	mux.HandleFunc("/healthz", b.handleHealthz)
+	mux.HandleFunc("/readyz", b.handleReadyz)
+
+	b.httpServer = &http.Server{
+		Addr:              b.cfg().HTTPAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.logger.Infof("HTTP listener on %s", b.cfg().HTTPAddr)
+		if err := b.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			b.logger.WithError(err).Error("HTTP listener failed")
+		}
+	}()
+}
+
+// stopHTTPServers stops the HTTP listeners, waiting for running requests
+func (b *Bot) stopHTTPServers() {
+	for _, server := range []*http.Server{b.httpServer, b.adminServer, b.controlServer} {
+		if server == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := server.Shutdown(ctx); err != nil {
+			b.logger.WithError(err).Warnf("Error stopping HTTP listener on %s", server.Addr)
+		}
+		cancel()
+	}
+}
+
+// activeListeners returns listeners by guild for guilds where the radio plays
+func (b *Bot) activeListeners() map[string]int {
+	listeners := make(map[string]int)
+	for _, guildID := range b.radioManager.GetAllGuildIDs() {
+		state, exists := b.radioManager.Get(guildID)
+		if !exists || !state.IsActive() {
+			continue
+		}
+		listeners[guildID] = b.countUsersInChannelFromState(guildID, state.GetChannelID())
+	}
+	return listeners
+}
@@ -0,0 +1,151 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// idleGracePeriod returns how long the bot stays in an empty channel for a guild
+func (b *Bot) idleGracePeriod(guildID string) time.Duration {
+	state := b.radioManager.GetOrCreate(guildID)
+	if grace := state.GetIdleGrace(); grace >= 0 {
+		return grace
+	}
+	return b.cfg().IdleGracePeriod
+}
+
+// scheduleIdleLeave leaves the channel once it stays empty for the grace period
+// The stream keeps running meanwhile (muted if configured), so a quick rejoin doesn't reconnect
+func (b *Bot) scheduleIdleLeave(guildID, channelID string) {
+	state := b.radioManager.GetOrCreate(guildID)
+	b.scheduleLeave(guildID, channelID, b.idleGracePeriod(guildID), state.IsIdleMute())
+}
+
+// scheduleLeave leaves the channel after a delay unless cancelled by cancelIdleLeave
+func (b *Bot) scheduleLeave(guildID, channelID string, grace time.Duration, mute bool) {
+	state := b.radioManager.GetOrCreate(guildID)
+
+	if grace <= 0 {
+		b.log(guildID).Infof("Last user left channel %s, stopping radio", channelID)
+		b.leaveVoice(guildID)
+		return
+	}
+
+	timer := time.AfterFunc(grace, func() {
+		b.onIdleTimeout(guildID, channelID)
+	})
+	if !state.StartIdle(timer, mute) {
+		// Already waiting
+		timer.Stop()
+		return
+	}
+
+	b.log(guildID).Infof("Leaving channel %s in %v unless someone returns (muted: %v)", channelID, grace, mute)
+}
+
+// onIdleTimeout leaves the channel if it is still empty after the grace period
+func (b *Bot) onIdleTimeout(guildID, channelID string) {
+	state := b.radioManager.GetOrCreate(guildID)
+	if !state.StopIdle() {
+		// Cancelled in the meantime
+		return
+	}
+
+	if !state.IsActive() || state.GetChannelID() != channelID {
+		return
+	}
+
+	if userCount := b.countUsersInChannelFromState(guildID, channelID); userCount > 0 {
+		b.log(guildID).Infof("%d users in channel %s after grace period, keeping radio", userCount, channelID)
+		return
+	}
+
+	b.log(guildID).Infof("Channel %s stayed empty for the grace period, stopping radio", channelID)
+	b.leaveVoice(guildID)
+}
+
+// cancelIdleLeave keeps the radio when a listener returns during the grace period
+func (b *Bot) cancelIdleLeave(guildID, channelID string) bool {
+	state := b.radioManager.GetOrCreate(guildID)
+	if !state.IsIdle() || state.GetChannelID() != channelID {
+		return false
+	}
+
+	if b.countUsersInChannelFromState(guildID, channelID) == 0 {
+		return false
+	}
+
+	if state.StopIdle() {
+		b.log(guildID).Infof("User returned to channel %s during grace period, keeping radio", channelID)
+		return true
+	}
+	return false
+}
+
+// handleIdle handles the !idle command
+// Configures how long the bot stays in an empty channel and whether it goes silent meanwhile
+func (b *Bot) handleIdle(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := m.GuildID
+	textChannelID := m.ChannelID
+	state := b.radioManager.GetOrCreate(guildID)
+
+	usage := "Использование: `!idle <секунды>`, `!idle default` или `!idle mute on|off`"
+
+	parts := strings.Fields(m.Content)
+	if len(parts) < 2 {
+		grace := b.idleGracePeriod(guildID)
+		source := "свой"
+		if state.GetIdleGrace() < 0 {
+			source = "по умолчанию"
+		}
+		mute := "нет"
+		if state.IsIdleMute() {
+			mute = "да"
+		}
+		s.ChannelMessageSend(textChannelID, fmt.Sprintf("Ожидание в пустом канале: **%v** (%s)\nБез звука во время ожидания: **%s**\n\n%s",
+			grace, source, mute, usage))
+		return
+	}
+
+	switch strings.ToLower(parts[1]) {
+	case "default":
+		state.SetIdleGrace(-1)
+		if !b.saveCommandState(s, textChannelID, guildID) {
+			return
+		}
+		s.ChannelMessageSend(textChannelID, fmt.Sprintf("✅ Ожидание в пустом канале: **%v** (по умолчанию)", b.cfg().IdleGracePeriod))
+	case "mute":
+		if len(parts) < 3 {
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+		switch strings.ToLower(parts[2]) {
+		case "on", "enable", "вкл", "да":
+			state.SetIdleMute(true)
+		case "off", "disable", "выкл", "нет":
+			state.SetIdleMute(false)
+		default:
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+		if !b.saveCommandState(s, textChannelID, guildID) {
+			return
+		}
+		s.ChannelMessageSend(textChannelID, "✅ Настройка сохранена")
+	default:
+		seconds, err := strconv.Atoi(parts[1])
+		if err != nil || seconds < 0 {
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+		state.SetIdleGrace(time.Duration(seconds) * time.Second)
+		if !b.saveCommandState(s, textChannelID, guildID) {
+			return
+		}
+		s.ChannelMessageSend(textChannelID, fmt.Sprintf("✅ Ожидание в пустом канале: **%v**", time.Duration(seconds)*time.Second))
+	}
+}
@@ -0,0 +1,157 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// isListener reports whether a voice state belongs to someone who counts as a listener
+// Bots, deafened members (unless the guild counts them) and ignored users or roles don't count
+func (b *Bot) isListener(guildID string, vs *discordgo.VoiceState) bool {
+	if vs == nil || vs.ChannelID == "" || vs.UserID == b.session.State.User.ID {
+		return false
+	}
+
+	policy := b.radioManager.GetOrCreate(guildID).GetListenerPolicy()
+
+	if !policy.CountDeafened && (vs.Deaf || vs.SelfDeaf) {
+		return false
+	}
+
+	if policy.IsUserIgnored(vs.UserID) {
+		return false
+	}
+
+	member, exists := b.lookupMember(guildID, vs.UserID)
+	if !exists {
+		// Requested over the gateway; not counted until it arrives, it may be a bot or ignored
+		b.log(guildID).Debugf("Member %s unknown, not counting as listener until it arrives", vs.UserID)
+		return false
+	}
+
+	if member.bot {
+		return false
+	}
+
+	return !policy.HasIgnoredRole(member.roles)
+}
+
+// isAFKChannel reports whether a channel is the guild's AFK channel
+func (b *Bot) isAFKChannel(guildID, channelID string) bool {
+	guild, err := b.session.State.Guild(guildID)
+	if err != nil {
+		return false
+	}
+	return guild.AfkChannelID != "" && guild.AfkChannelID == channelID
+}
+
+// parseMentionID extracts an ID from a user or role mention, or returns the argument as is
+func parseMentionID(arg string) string {
+	arg = strings.TrimPrefix(arg, "<")
+	arg = strings.TrimSuffix(arg, ">")
+	arg = strings.TrimPrefix(arg, "@")
+	arg = strings.TrimPrefix(arg, "!")
+	arg = strings.TrimPrefix(arg, "&")
+	return arg
+}
+
+// handleListeners handles the !listeners command
+// Configures who counts as a listener when deciding whether to stay or leave
+func (b *Bot) handleListeners(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := m.GuildID
+	textChannelID := m.ChannelID
+	state := b.radioManager.GetOrCreate(guildID)
+
+	usage := "Использование:\n" +
+		"`!listeners deafened count|ignore` — считать ли слушателями тех, кто отключил звук\n" +
+		"`!listeners ignore|unignore user <@пользователь>`\n" +
+		"`!listeners ignore|unignore role <@роль>`"
+
+	parts := strings.Fields(m.Content)
+	if len(parts) < 2 {
+		policy := state.GetListenerPolicy()
+
+		deafened := "не считаются"
+		if policy.CountDeafened {
+			deafened = "считаются"
+		}
+
+		users := "нет"
+		if len(policy.IgnoredUsers) > 0 {
+			mentions := make([]string, 0, len(policy.IgnoredUsers))
+			for _, id := range policy.IgnoredUsers {
+				mentions = append(mentions, "<@"+id+">")
+			}
+			users = strings.Join(mentions, ", ")
+		}
+
+		roles := "нет"
+		if len(policy.IgnoredRoles) > 0 {
+			mentions := make([]string, 0, len(policy.IgnoredRoles))
+			for _, id := range policy.IgnoredRoles {
+				mentions = append(mentions, "<@&"+id+">")
+			}
+			roles = strings.Join(mentions, ", ")
+		}
+
+		s.ChannelMessageSendComplex(textChannelID, &discordgo.MessageSend{
+			Content: fmt.Sprintf("Пользователи без звука: **%s**\nИгнорируемые пользователи: %s\nИгнорируемые роли: %s\n\n%s",
+				deafened, users, roles, usage),
+			// Don't ping the listed users and roles
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		return
+	}
+
+	switch strings.ToLower(parts[1]) {
+	case "deafened":
+		if len(parts) < 3 {
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+		switch strings.ToLower(parts[2]) {
+		case "count":
+			state.SetCountDeafened(true)
+		case "ignore":
+			state.SetCountDeafened(false)
+		default:
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+	case "ignore", "unignore":
+		if len(parts) < 4 {
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+		ignored := strings.ToLower(parts[1]) == "ignore"
+		id := parseMentionID(parts[3])
+		switch strings.ToLower(parts[2]) {
+		case "user":
+			state.SetUserIgnored(id, ignored)
+		case "role":
+			state.SetRoleIgnored(id, ignored)
+		default:
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+	default:
+		s.ChannelMessageSend(textChannelID, usage)
+		return
+	}
+
+	if !b.saveCommandState(s, textChannelID, guildID) {
+		return
+	}
+	s.ChannelMessageSend(textChannelID, "✅ Настройка сохранена")
+
+	// The policy may have turned the auto-channel empty or occupied
+	if channelID := state.GetChannelID(); state.IsActive() && state.IsAutoChannel(channelID) {
+		if b.countUsersInChannelFromState(guildID, channelID) == 0 {
+			b.onAutoChannelEmptied(guildID, channelID)
+		} else {
+			b.cancelIdleLeave(guildID, channelID)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// minHookLevel is the least severe level passed to the bot's hooks, whatever the log level
+// Info messages are the activity shown on the dashboard even when only warnings are written
+const minHookLevel = logrus.InfoLevel
+
+// logOutput writes the entries of a bot logger at or above its level, like logger.Out would
+// It is a hook so the logger can pass less severe entries to other hooks without writing them
+type logOutput struct {
+	mu        sync.Mutex
+	out       io.Writer
+	formatter logrus.Formatter
+	level     logrus.Level
+}
+
+// Levels returns every level, entries are filtered in Fire so the level can change
+func (o *logOutput) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire writes an entry if it's at or above the level
+func (o *logOutput) Fire(entry *logrus.Entry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if entry.Level > o.level {
+		return nil
+	}
+	serialized, err := o.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = o.out.Write(serialized)
+	return err
+}
+
+// setLevel changes the least severe level written
+func (o *logOutput) setLevel(level logrus.Level) {
+	o.mu.Lock()
+	o.level = level
+	o.mu.Unlock()
+}
+
+// discardFormatter formats nothing, bot loggers write through their logOutput instead
+type discardFormatter struct{}
+
+func (discardFormatter) Format(*logrus.Entry) ([]byte, error) {
+	return nil, nil
+}
+
+// newBotLogger creates a logger writing like logger from level up, with the hooks of logger and the given ones
+// The given hooks also receive entries down to minHookLevel
+// The bot's hooks stay with the bot instead of piling up on logger across restarts
+func newBotLogger(logger *logrus.Logger, level logrus.Level, hooks ...logrus.Hook) (*logrus.Logger, *logOutput) {
+	output := &logOutput{out: logger.Out, formatter: logger.Formatter, level: level}
+
+	levelHooks := make(logrus.LevelHooks)
+	for hookLevel, existing := range logger.Hooks {
+		levelHooks[hookLevel] = append(levelHooks[hookLevel], existing...)
+	}
+	levelHooks.Add(output)
+	for _, hook := range hooks {
+		levelHooks.Add(hook)
+	}
+
+	loggerLevel := level
+	if loggerLevel < minHookLevel {
+		loggerLevel = minHookLevel
+	}
+	return &logrus.Logger{
+		Out:          io.Discard,
+		Hooks:        levelHooks,
+		Formatter:    discardFormatter{},
+		ReportCaller: logger.ReportCaller,
+		Level:        loggerLevel,
+		ExitFunc:     logger.ExitFunc,
+	}, output
+}
+
+// newDebugLogger creates a bot logger like newBotLogger but at least at debug level
+// Used for guilds with debug logging turned on
+func newDebugLogger(logger *logrus.Logger, level logrus.Level, hooks ...logrus.Hook) (*logrus.Logger, *logOutput) {
+	return newBotLogger(logger, debugLevel(level), hooks...)
+}
+
+// debugLevel returns level, or debug if that's less verbose
+func debugLevel(level logrus.Level) logrus.Level {
+	if level < logrus.DebugLevel {
+		return logrus.DebugLevel
+	}
+	return level
+}
+
+// setLogLevel changes the level of every logger of the bot
+func (b *Bot) setLogLevel(level logrus.Level) {
+	b.logOutput.setLevel(level)
+	b.logger.SetLevel(max(level, minHookLevel))
+	b.debugOutput.setLevel(debugLevel(level))
+	b.debugLogger.SetLevel(debugLevel(level))
+}
+
+// log returns a logger for messages about a guild
+// Debug messages of guilds with debug logging turned on are written whatever the global level
+func (b *Bot) log(guildID string) *logrus.Entry {
+	logger := b.logger
+	if b.isGuildDebug(guildID) {
+		logger = b.debugLogger
+	}
+	return logger.WithField("guild", guildID)
+}
+
+// isGuildDebug reports whether debug logging is turned on for a guild
+func (b *Bot) isGuildDebug(guildID string) bool {
+	b.debugMu.RLock()
+	defer b.debugMu.RUnlock()
+	return b.debugGuilds[guildID]
+}
+
+// setGuildDebug turns debug logging of a guild on or off
+func (b *Bot) setGuildDebug(guildID string, enabled bool) {
+	b.debugMu.Lock()
+	if enabled {
+		b.debugGuilds[guildID] = true
+	} else {
+		delete(b.debugGuilds, guildID)
+	}
+	b.debugMu.Unlock()
+
+	b.log(guildID).WithField("debug", enabled).Info("Guild debug logging changed")
+}
+
+// handleDebug handles the !debug command
+// Turns detailed logging of the guild on or off until the bot restarts
+func (b *Bot) handleDebug(s *discordgo.Session, m *discordgo.MessageCreate) {
+	textChannelID := m.ChannelID
+
+	if !b.isGuildAdmin(s, m) {
+		s.ChannelMessageSend(textChannelID, "Эта команда доступна только администраторам сервера (право **Управлять сервером**).")
+		return
+	}
+
+	parts := strings.Fields(m.Content)
+	if len(parts) < 2 {
+		if b.isGuildDebug(m.GuildID) {
+			s.ChannelMessageSend(textChannelID, "Подробные логи для этого сервера включены. Выключить: `!debug off`")
+		} else {
+			s.ChannelMessageSend(textChannelID, "Подробные логи для этого сервера выключены. Включить: `!debug on`")
+		}
+		return
+	}
+
+	switch strings.ToLower(parts[1]) {
+	case "on":
+		b.setGuildDebug(m.GuildID, true)
+		s.ChannelMessageSend(textChannelID, "🔍 Подробные логи для этого сервера включены до `!debug off` или перезапуска бота.")
+	case "off":
+		b.setGuildDebug(m.GuildID, false)
+		s.ChannelMessageSend(textChannelID, "Подробные логи для этого сервера выключены.")
+	default:
+		s.ChannelMessageSend(textChannelID, "Использование: `!debug on|off`")
+	}
+}
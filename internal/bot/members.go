@@ -0,0 +1,193 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// memberInfo is what the bot needs to know about a guild member
+type memberInfo struct {
+	bot      bool
+	roles    []string
+	cachedAt time.Time
+}
+
+// memberCache keeps bot flags and roles of guild members, fed by gateway events,
+// so deciding who is a listener never needs a REST round-trip
+type memberCache struct {
+	members   map[string]map[string]memberInfo // guild ID -> user ID -> member
+	requested map[string]time.Time             // guild ID + user ID -> last gateway request
+	ttl       time.Duration                    // How long a member is trusted, forever if 0
+	interval  time.Duration                    // How often the same missing member may be requested
+	mu        sync.RWMutex
+}
+
+// newMemberCache creates an empty member cache
+// Without member events nothing tells the cache that roles changed, so entries expire after ttl
+func newMemberCache(ttl, interval time.Duration) *memberCache {
+	return &memberCache{
+		members:   make(map[string]map[string]memberInfo),
+		requested: make(map[string]time.Time),
+		ttl:       ttl,
+		interval:  interval,
+	}
+}
+
+// put stores a member; members without a user are ignored
+func (c *memberCache) put(guildID string, member *discordgo.Member) {
+	if member == nil || member.User == nil || guildID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	guild, exists := c.members[guildID]
+	if !exists {
+		guild = make(map[string]memberInfo)
+		c.members[guildID] = guild
+	}
+	guild[member.User.ID] = memberInfo{
+		bot:      member.User.Bot,
+		roles:    append([]string(nil), member.Roles...),
+		cachedAt: time.Now(),
+	}
+	delete(c.requested, guildID+"/"+member.User.ID)
+}
+
+// get returns a cached member, an expired one counts as missing
+func (c *memberCache) get(guildID, userID string) (memberInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	info, exists := c.members[guildID][userID]
+	if exists && c.ttl > 0 && time.Since(info.cachedAt) > c.ttl {
+		return memberInfo{}, false
+	}
+	return info, exists
+}
+
+// remove drops a member, e.g. after they left the guild
+func (c *memberCache) remove(guildID, userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.members[guildID], userID)
+}
+
+// removeGuild drops every member of a guild
+func (c *memberCache) removeGuild(guildID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.members, guildID)
+	for key := range c.requested {
+		if strings.HasPrefix(key, guildID+"/") {
+			delete(c.requested, key)
+		}
+	}
+}
+
+// shouldRequest reports whether a missing member should be requested now,
+// so a member that never arrives isn't requested on every event
+func (c *memberCache) shouldRequest(guildID, userID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Forget requests old enough to be repeated, so the map doesn't grow for the life of a guild
+	for key, last := range c.requested {
+		if time.Since(last) >= c.interval {
+			delete(c.requested, key)
+		}
+	}
+
+	key := guildID + "/" + userID
+	if _, exists := c.requested[key]; exists {
+		return false
+	}
+	c.requested[key] = time.Now()
+	return true
+}
+
+// lookupMember returns a member from the cache without any REST calls
+// On a miss the member is requested over the gateway and arrives with a members chunk
+func (b *Bot) lookupMember(guildID, userID string) (memberInfo, bool) {
+	if info, exists := b.members.get(guildID, userID); exists {
+		return info, true
+	}
+
+	// discordgo's own state may know the member from an event we didn't see,
+	// without member events it is as stale as an expired entry
+	if b.members.ttl == 0 {
+		if member, err := b.session.State.Member(guildID, userID); err == nil {
+			b.members.put(guildID, member)
+			return b.members.get(guildID, userID)
+		}
+	}
+
+	if b.members.shouldRequest(guildID, userID) {
+		b.log(guildID).Debugf("Member %s not cached, requesting from gateway", userID)
+		if err := b.session.RequestGuildMembersList(guildID, []string{userID}, 0, "", false); err != nil {
+			b.log(guildID).WithError(err).Debugf("Failed to request member %s", userID)
+		}
+	}
+
+	return memberInfo{}, false
+}
+
+// onGuildCreate seeds the member cache with the members sent with the guild,
+// which include everyone in a voice channel
+func (b *Bot) onGuildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
+	if g.Guild == nil {
+		return
+	}
+	for _, member := range g.Members {
+		b.members.put(g.ID, member)
+	}
+}
+
+// onGuildMemberAdd caches a member who joined the guild
+func (b *Bot) onGuildMemberAdd(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	if m.Member != nil {
+		b.members.put(m.GuildID, m.Member)
+	}
+}
+
+// onGuildMemberUpdate refreshes a member's roles
+func (b *Bot) onGuildMemberUpdate(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+	if m.Member != nil {
+		b.members.put(m.GuildID, m.Member)
+	}
+}
+
+// onGuildMemberRemove forgets a member who left the guild
+func (b *Bot) onGuildMemberRemove(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	if m.Member != nil && m.User != nil {
+		b.members.remove(m.GuildID, m.User.ID)
+	}
+}
+
+// onGuildMembersChunk caches members requested over the gateway
+// Joins of requested members were ignored until they arrived, so their voice states are handled again:
+// a member may be a listener the radio was about to leave without, the followed user or an auto-connect trigger
+func (b *Bot) onGuildMembersChunk(s *discordgo.Session, c *discordgo.GuildMembersChunk) {
+	for _, member := range c.Members {
+		b.members.put(c.GuildID, member)
+	}
+	for _, member := range c.Members {
+		if member == nil || member.User == nil {
+			continue
+		}
+		vs, err := s.State.VoiceState(c.GuildID, member.User.ID)
+		if err != nil || vs.ChannelID == "" {
+			continue
+		}
+		b.log(c.GuildID).WithFields(logrus.Fields{"user": member.User.ID, "channel": vs.ChannelID}).
+			Debug("Requested member arrived, handling their voice state again")
+		// Handled like a join the bot just learned about, without the stale member of the stored state
+		joined := *vs
+		joined.Member = nil
+		b.onVoiceStateUpdate(s, &discordgo.VoiceStateUpdate{VoiceState: &joined})
+	}
+}
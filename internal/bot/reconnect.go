@@ -4,6 +4,11 @@ import (
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ankogit/4duk-discord-bot/internal/audio"
+	"github.com/ankogit/4duk-discord-bot/internal/config"
+	"github.com/ankogit/4duk-discord-bot/internal/metrics"
 )
 
 // reconnectRadio attempts to reconnect the radio
@@ -14,20 +19,22 @@ func (b *Bot) reconnectRadio(guildID string) {
 	}
 
 	if !state.IsActive() {
-		b.logger.Infof("[%s] Radio not active anymore, skipping reconnect", guildID)
+		b.log(guildID).Info("Radio not active anymore, skipping reconnect")
 		return
 	}
 
 	attempts := state.GetReconnectAttempts()
 	channelID := state.GetChannelID()
 
-	if attempts >= b.config.MaxReconnectAttempts {
-		b.logger.Errorf("[%s] Reached max reconnect attempts (%d). Giving up", guildID, attempts)
+	if attempts >= b.cfg().MaxReconnectAttempts {
+		b.log(guildID).Errorf("Reached max reconnect attempts (%d). Giving up", attempts)
+		state.MarkFailing()
+		b.metrics.Reconnect(guildID, metrics.ReconnectGaveUp)
 		return
 	}
 
 	if channelID == "" {
-		b.logger.Warnf("[%s] No channel recorded to reconnect", guildID)
+		b.log(guildID).Warn("No channel recorded to reconnect")
 		return
 	}
 
@@ -36,13 +43,15 @@ func (b *Bot) reconnectRadio(guildID string) {
 	userCount := b.countUsersInChannelFromState(guildID, channelID)
 	if userCount == 0 {
 		// Double-check with a small delay to avoid false positives
-		time.Sleep(500 * time.Millisecond)
+		time.Sleep(b.cfg().EmptyRecheckDelay)
 		userCount = b.countUsersInChannelFromState(guildID, channelID)
 
 		if userCount == 0 {
-			b.logger.Infof("[%s] No users in channel %s (confirmed), stopping radio instead of reconnecting", guildID, channelID)
+			b.log(guildID).Infof("No users in channel %s (confirmed), stopping radio instead of reconnecting", channelID)
+			b.metrics.Reconnect(guildID, metrics.ReconnectNoListeners)
 			state.SetActive(false)
 			state.ResetReconnectAttempts()
+			b.endSession(guildID)
 
 			// Disconnect if connected
 			if vc, exists := b.session.VoiceConnections[guildID]; exists {
@@ -53,14 +62,14 @@ func (b *Bot) reconnectRadio(guildID string) {
 				}()
 			}
 		} else {
-			b.logger.Debugf("[%s] UserCount changed to %d after delay, proceeding with reconnect", guildID, userCount)
+			b.log(guildID).Debugf("UserCount changed to %d after delay, proceeding with reconnect", userCount)
 		}
 		return
 	}
 
 	// Calculate backoff
-	backoff := time.Duration(1<<uint(attempts)) * b.config.ReconnectBackoffBase
-	b.logger.Infof("[%s] Reconnect attempt #%d, sleeping %v before trying", guildID, attempts+1, backoff)
+	backoff := time.Duration(1<<uint(attempts)) * b.cfg().ReconnectBackoffBase
+	b.log(guildID).Infof("Reconnect attempt #%d, sleeping %v before trying", attempts+1, backoff)
 
 	select {
 	case <-time.After(backoff):
@@ -70,17 +79,18 @@ func (b *Bot) reconnectRadio(guildID string) {
 
 	state.IncrementReconnectAttempts()
 
-	// Connect to channel
-	vc, err := b.connectToChannel(b.session, guildID, channelID)
+	// Connect to channel, permissions or the user limit may have changed meanwhile
+	var vc *discordgo.VoiceConnection
+	err := b.checkVoiceJoin(guildID, channelID)
+	if err == nil {
+		vc, err = b.connectToChannel(b.session, guildID, channelID)
+	}
 	if err != nil {
-		b.logger.WithError(err).Errorf("[%s] Failed to reconnect to channel", guildID)
+		b.log(guildID).WithError(err).Error("Failed to reconnect to channel")
+		b.metrics.Reconnect(guildID, metrics.ReconnectFailure)
 		// Schedule another attempt
-		if state.IsActive() && state.GetReconnectAttempts() < b.config.MaxReconnectAttempts {
-			b.wg.Add(1)
-			go func() {
-				defer b.wg.Done()
-				b.reconnectRadio(guildID)
-			}()
+		if state.IsActive() && state.GetReconnectAttempts() < b.cfg().MaxReconnectAttempts {
+			b.goGuild(guildID, "reconnect", func() { b.reconnectRadio(guildID) })
 		}
 		return
 	}
@@ -91,8 +101,66 @@ func (b *Bot) reconnectRadio(guildID string) {
 	// Start radio again
 	err = b.startRadio(vc, guildID)
 	if err != nil {
-		b.logger.WithError(err).Errorf("[%s] Failed to restart radio after reconnect", guildID)
+		b.log(guildID).WithError(err).Error("Failed to restart radio after reconnect")
+		b.metrics.Reconnect(guildID, metrics.ReconnectFailure)
+		return
+	}
+	b.metrics.Reconnect(guildID, metrics.ReconnectSuccess)
+}
+
+// restartStream restarts a stream that ended because the radio station failed
+// The voice connection is kept, if it died meanwhile reconnectRadio takes over
+func (b *Bot) restartStream(guildID string, streamID uint64, cause *audio.UpstreamError, played time.Duration) {
+	state, exists := b.radioManager.Get(guildID)
+	if !exists || !state.IsActive() {
+		return
+	}
+
+	log := b.log(guildID).WithFields(logrus.Fields{"station": config.RedactURL(b.station(guildID)), "status": cause.Status})
+
+	if played > b.cfg().StreamHealthyAfter {
+		state.ResetReconnectAttempts()
+	}
+
+	attempts := state.GetReconnectAttempts()
+	if attempts >= b.cfg().MaxReconnectAttempts {
+		log.Errorf("Radio station still failing after %d attempts. Giving up", attempts)
+		state.MarkFailing()
+		b.metrics.Reconnect(guildID, metrics.ReconnectGaveUp)
+		return
+	}
+
+	backoff := time.Duration(1<<uint(attempts)) * b.cfg().ReconnectBackoffBase
+	if cause.Permanent() {
+		// The station refused the stream, give it more time before asking again
+		backoff *= 4
+	}
+	log.Warnf("Radio station failed, restarting stream in %v (attempt #%d)", backoff, attempts+1)
+
+	select {
+	case <-time.After(backoff):
+	case <-b.ctx.Done():
+		return
+	}
+
+	// Stopped or restarted by someone else while we waited
+	if !state.IsActive() || !state.IsCurrentStream(streamID) {
+		return
+	}
+	state.IncrementReconnectAttempts()
+
+	vc, exists := b.session.VoiceConnections[guildID]
+	if !exists || vc.Status != discordgo.VoiceConnectionStatusReady {
+		b.reconnectRadio(guildID)
+		return
 	}
+
+	if err := b.startRadio(vc, guildID); err != nil {
+		log.WithError(err).Error("Failed to restart stream")
+		b.metrics.Reconnect(guildID, metrics.ReconnectFailure)
+		return
+	}
+	b.metrics.Reconnect(guildID, metrics.ReconnectSuccess)
 }
 
 // voiceCheckLoop periodically checks voice connections
@@ -100,14 +168,17 @@ func (b *Bot) reconnectRadio(guildID string) {
 func (b *Bot) voiceCheckLoop() {
 	defer b.wg.Done()
 
-	ticker := time.NewTicker(b.config.VoiceCheckInterval)
+	ticker := time.NewTicker(b.cfg().VoiceCheckInterval)
 	defer ticker.Stop()
 
+	b.heartbeat.Store(time.Now().UnixNano())
+
 	for {
 		select {
 		case <-b.ctx.Done():
 			return
 		case <-ticker.C:
+			b.heartbeat.Store(time.Now().UnixNano())
 			b.checkVoiceConnections()
 		}
 	}
@@ -128,145 +199,60 @@ func (b *Bot) checkVoiceConnections() {
 		// User count is handled by onVoiceStateUpdate events
 		vc, exists := b.session.VoiceConnections[guildID]
 		if !exists || vc == nil || vc.Status != discordgo.VoiceConnectionStatusReady {
-			b.logger.Infof("[%s] voice_check_loop: detected dead vc -> scheduling reconnect", guildID)
-			b.wg.Add(1)
-			go func(gid string) {
-				defer b.wg.Done()
-				b.reconnectRadio(gid)
-			}(guildID)
+			state.MarkFailing()
+			b.log(guildID).Info("voice_check_loop: detected dead vc -> scheduling reconnect")
+			b.goGuild(guildID, "reconnect", func() { b.reconnectRadio(guildID) })
 		}
 	}
 }
 
-// checkAutoConnectChannels checks saved auto-channels and connects if users are present
+// checkAutoConnectChannels connects guilds whose auto-channels are occupied at startup,
+// when no voice state update tells the bot about listeners already there
+// The auto-connect rules apply as they do for a join
 func (b *Bot) checkAutoConnectChannels() {
-	// Get all guilds where bot is a member
-	guilds := b.session.State.Guilds
-	if len(guilds) == 0 {
-		return
-	}
-
-	for _, guild := range guilds {
-		guildID := guild.ID
-
-		// Get or create state (this will load from config if exists)
-		state := b.radioManager.GetOrCreate(guildID)
+	defer b.wg.Done()
 
-		// Check if auto-connect is enabled
-		if !state.IsAutoConnectEnabled() {
-			b.logger.Debugf("[%s] Auto-connect disabled, skipping", guildID)
+	// Guilds arrive one by one after Ready, all of them within the timeout
+	deadline := time.Now().Add(b.cfg().GuildAvailableTimeout)
+	for _, guildID := range b.radioManager.GetAllGuildIDs() {
+		state, exists := b.radioManager.Get(guildID)
+		if !exists || !state.IsAutoConnectEnabled() || len(state.GetAutoChannels()) == 0 {
 			continue
 		}
-
-		autoChannelID := state.GetAutoChannelID()
-		if autoChannelID == "" {
-			// No auto-channel set for this guild
-			b.logger.Debugf("[%s] No auto-channel set, skipping", guildID)
+		if state.GetSession() != nil {
+			// Resumed by restoreSessions instead
 			continue
 		}
 
-		b.logger.Debugf("[%s] Checking auto-connect for channel %s", guildID, autoChannelID)
-
-		// Check if radio is already active
-		if state.IsActive() {
-			// Check if we're in the right channel
-			vc, exists := b.session.VoiceConnections[guildID]
-			if exists && vc != nil && vc.Status == discordgo.VoiceConnectionStatusReady {
-				// Check if we're in the correct channel
-				botVS, err := b.session.State.VoiceState(guildID, b.session.State.User.ID)
-				if err == nil && botVS != nil && botVS.ChannelID == autoChannelID {
-					// Already connected to the right channel
-					continue
-				}
+		if !b.waitForGuild(guildID, time.Until(deadline)) {
+			if b.ctx.Err() != nil {
+				return
 			}
+			b.log(guildID).Debug("Guild unavailable, skipping startup auto-connect")
+			continue
 		}
 
-		// Count users in the auto-channel (excluding bots)
-		// Use guild from loop, no need to fetch again
-		userCount := 0
-		for _, vs := range guild.VoiceStates {
-			if vs.ChannelID == autoChannelID && vs.UserID != b.session.State.User.ID {
-				// Check if user is a bot
-				member, err := b.session.GuildMember(guildID, vs.UserID)
-				if err == nil && member != nil && !member.User.Bot {
-					userCount++
-				}
-			}
+		if state.IsActive() || b.isFollowing(guildID) {
+			continue
 		}
-
-		// Only auto-connect if there are users in the channel
-		if userCount == 0 {
-			b.logger.Debugf("[%s] No users in auto-channel %s, skipping", guildID, autoChannelID)
+		if !b.isAutoConnectTimeAllowed(guildID) {
+			b.log(guildID).Debug("Outside of auto-connect days and hours, skipping startup auto-connect")
 			continue
 		}
 
-		// Skip if already connecting (check if there's an active connection attempt)
-		// This is handled by checking if radio is already active above
-
-		// Auto-connect to the channel
-		b.logger.Infof("[%s] Auto-connecting to saved channel %s (%d users present)", guildID, autoChannelID, userCount)
-
-		b.wg.Add(1)
-		go func(gid, cid string) {
-			defer b.wg.Done()
-			defer func() {
-				if r := recover(); r != nil {
-					b.logger.WithField("panic", r).
-						Errorf("[%s] Panic in auto-connect goroutine", gid)
-				}
-			}()
-
-			state := b.radioManager.GetOrCreate(gid)
-			state.SetActive(true)
-			state.SetChannelID(cid)
-			state.ResetReconnectAttempts()
-
-			vc, err := b.connectToChannel(b.session, gid, cid)
-			if err != nil {
-				b.logger.WithError(err).Errorf("[%s] Failed to auto-connect to channel", gid)
-				state.SetActive(false)
-				return
-			}
-
-			if vc == nil {
-				b.logger.Errorf("[%s] Voice connection is nil after auto-connect", gid)
-				state.SetActive(false)
-				return
-			}
-
-			// Start radio
-			err = b.startRadio(vc, gid)
-			if err != nil {
-				b.logger.WithError(err).Errorf("[%s] Failed to start radio after auto-connect", gid)
-				state.SetActive(false)
-			}
-		}(guildID, autoChannelID)
-	}
-}
-
-// countUsersInChannel counts non-bot users in a voice channel
-func (b *Bot) countUsersInChannel(guildID, channelID string) int {
-	guild, err := b.session.Guild(guildID)
-	if err != nil {
-		b.logger.WithError(err).Debugf("[%s] Failed to get guild info", guildID)
-		return 0
-	}
-
-	userCount := 0
-	for _, vs := range guild.VoiceStates {
-		if vs.ChannelID == channelID && vs.UserID != b.session.State.User.ID {
-			// Check if user is a bot
-			member, err := b.session.GuildMember(guildID, vs.UserID)
-			if err == nil && member != nil && !member.User.Bot {
-				userCount++
-			}
+		// Minimum listeners and trigger roles are checked by qualifyingListeners
+		channelID, userCount := b.pickAutoChannel(guildID)
+		if channelID == "" {
+			b.log(guildID).Debug("No qualifying listeners in auto-channels, skipping startup auto-connect")
+			continue
 		}
-	}
 
-	return userCount
+		b.log(guildID).WithField("channel", channelID).Infof("Auto-connecting at startup (%d listeners present)", userCount)
+		b.startAutoConnect(guildID, channelID, 0)
+	}
 }
 
-// countUsersInChannelFromState counts non-bot users in a voice channel using session state
+// countUsersInChannelFromState counts listeners in a voice channel using session state
 // This is more up-to-date than guild.VoiceStates
 func (b *Bot) countUsersInChannelFromState(guildID, channelID string) int {
 	userCount := 0
@@ -274,22 +260,13 @@ func (b *Bot) countUsersInChannelFromState(guildID, channelID string) int {
 	// Get all voice states from session state
 	guild, err := b.session.State.Guild(guildID)
 	if err != nil {
-		b.logger.WithError(err).Debugf("[%s] Failed to get guild from state", guildID)
+		b.log(guildID).WithError(err).Debug("Failed to get guild from state")
 		return 0
 	}
 
 	for _, vs := range guild.VoiceStates {
-		if vs.ChannelID == channelID && vs.UserID != b.session.State.User.ID {
-			// Check if user is a bot
-			// Try State.Member first (faster, uses cache)
-			member, err := b.session.State.Member(guildID, vs.UserID)
-			if err != nil {
-				// Fallback to GuildMember if not in cache
-				member, err = b.session.GuildMember(guildID, vs.UserID)
-			}
-			if err == nil && member != nil && !member.User.Bot {
-				userCount++
-			}
+		if vs.ChannelID == channelID && b.isListener(guildID, vs) {
+			userCount++
 		}
 	}
 
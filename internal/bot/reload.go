@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ankogit/4duk-discord-bot/internal/config"
+)
+
+// reloadResult describes what a reload changed
+type reloadResult struct {
+	Applied []string // Settings changed live
+	Restart []string // Changed settings that only take effect after a restart
+	Guilds  int      // Guilds whose saved settings were read again
+}
+
+// reload reads the configuration and the saved guild settings again,
+// applying what can change while the bot runs
+func (b *Bot) reload() (*reloadResult, error) {
+	next, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration, nothing changed: %w", err)
+	}
+
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	previous := b.cfg()
+	current, applied, restart := previous.Reload(next)
+	b.config.Store(current)
+
+	if current.LogLevel != previous.LogLevel {
+		if level, err := logrus.ParseLevel(current.LogLevel); err == nil {
+			b.setLogLevel(level)
+		}
+	}
+	if current.MetricsGuildLabels != previous.MetricsGuildLabels {
+		b.metrics.SetGuildLabels(current.MetricsGuildLabels)
+	}
+	b.streamer.SetConfig(streamerConfig(current))
+	if current.RadioURL != previous.RadioURL {
+		b.restartStreams()
+	}
+
+	result := &reloadResult{Applied: applied, Restart: restart}
+	if result.Guilds, err = b.radioManager.ReloadConfig(); err != nil {
+		return result, fmt.Errorf("configuration reloaded, but guild settings weren't: %w", err)
+	}
+
+	log := b.logger.WithField("guilds", result.Guilds)
+	if len(applied) > 0 {
+		log = log.WithField("applied", applied)
+	}
+	if len(restart) > 0 {
+		log = log.WithField("needs_restart", restart)
+		log.Warn("Configuration reloaded, some changes need a restart")
+	} else {
+		log.Info("Configuration reloaded")
+	}
+	return result, nil
+}
+
+// restartStreams restarts the streams playing RADIO_URL, to switch to another station
+// Guilds with their own station keep playing
+func (b *Bot) restartStreams() {
+	for _, guildID := range b.radioManager.GetAllGuildIDs() {
+		state, exists := b.radioManager.Get(guildID)
+		if !exists || state.GetStation() != "" {
+			continue
+		}
+		b.switchStation(guildID)
+	}
+}
+
+// switchStation restarts the stream of a playing guild, so it plays its current station
+func (b *Bot) switchStation(guildID string) {
+	state, exists := b.radioManager.Get(guildID)
+	if !exists || !state.IsActive() {
+		return
+	}
+	vc, exists := b.session.VoiceConnections[guildID]
+	if !exists || vc.Status != discordgo.VoiceConnectionStatusReady {
+		// Picks up the new station when it reconnects
+		return
+	}
+	b.log(guildID).WithField("station", config.RedactURL(b.station(guildID))).Info("Switching to the new station")
+	if err := b.startRadio(vc, guildID); err != nil {
+		b.log(guildID).WithError(err).Error("Failed to restart stream")
+	}
+}
+
+// reloadOnSignal reloads the configuration on every SIGHUP received from signals
+// The signals are registered once by the caller, so none is lost or kills the process between restarts
+func (b *Bot) reloadOnSignal(signals <-chan os.Signal) {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case <-signals:
+			b.logger.Info("SIGHUP received, reloading configuration")
+			if _, err := b.reload(); err != nil {
+				b.logger.WithError(err).Error("Failed to reload configuration")
+			}
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
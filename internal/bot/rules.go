@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/ankogit/4duk-discord-bot/internal/radio"
+)
+
+// weekdayNames maps command arguments to weekdays
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "вс": time.Sunday,
+	"mon": time.Monday, "пн": time.Monday,
+	"tue": time.Tuesday, "вт": time.Tuesday,
+	"wed": time.Wednesday, "ср": time.Wednesday,
+	"thu": time.Thursday, "чт": time.Thursday,
+	"fri": time.Friday, "пт": time.Friday,
+	"sat": time.Saturday, "сб": time.Saturday,
+}
+
+// weekdayShortNames are the names used when showing weekdays, indexed by time.Weekday
+var weekdayShortNames = []string{"вс", "пн", "вт", "ср", "чт", "пт", "сб"}
+
+// isAutoConnectTimeAllowed reports whether the guild's days and hours allow auto-connect now
+func (b *Bot) isAutoConnectTimeAllowed(guildID string) bool {
+	rules := b.radioManager.GetOrCreate(guildID).GetAutoConnectRules()
+	allowed, err := rules.AllowsTime(time.Now())
+	if err != nil {
+		b.log(guildID).WithError(err).Warn("Invalid auto-connect schedule, ignoring it")
+		return true
+	}
+	return allowed
+}
+
+// qualifyingListeners counts listeners in a channel if they satisfy the guild's auto-connect rules
+// Returns 0 when there are fewer than the minimum or nobody with a trigger role is present
+func (b *Bot) qualifyingListeners(guildID, channelID string) int {
+	guild, err := b.session.State.Guild(guildID)
+	if err != nil {
+		b.log(guildID).WithError(err).Debug("Failed to get guild from state")
+		return 0
+	}
+
+	rules := b.radioManager.GetOrCreate(guildID).GetAutoConnectRules()
+
+	count := 0
+	triggered := len(rules.TriggerRoles) == 0
+	for _, vs := range guild.VoiceStates {
+		if vs.ChannelID != channelID || !b.isListener(guildID, vs) {
+			continue
+		}
+		count++
+		if !triggered {
+			member, exists := b.lookupMember(guildID, vs.UserID)
+			triggered = exists && rules.AllowsRoles(member.roles)
+		}
+	}
+
+	if !triggered || count < rules.RequiredListeners() {
+		return 0
+	}
+	return count
+}
+
+// canTriggerAutoConnect reports whether a member's roles allow them to trigger auto-connect
+func (b *Bot) canTriggerAutoConnect(guildID, userID string) bool {
+	rules := b.radioManager.GetOrCreate(guildID).GetAutoConnectRules()
+	if len(rules.TriggerRoles) == 0 {
+		return true
+	}
+	member, exists := b.lookupMember(guildID, userID)
+	return exists && rules.AllowsRoles(member.roles)
+}
+
+// handleAutoRules handles the !autorules command
+// Configures minimum listeners, allowed days and hours and trigger roles for auto-connect
+func (b *Bot) handleAutoRules(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := m.GuildID
+	textChannelID := m.ChannelID
+	state := b.radioManager.GetOrCreate(guildID)
+	rules := state.GetAutoConnectRules()
+
+	usage := "Использование:\n" +
+		"`!autorules min <число>` — минимум слушателей для подключения\n" +
+		"`!autorules days пн,вт,ср|all` — дни недели\n" +
+		"`!autorules hours 18:00-23:00|all` — часы\n" +
+		"`!autorules timezone Europe/Moscow` — часовой пояс\n" +
+		"`!autorules role add|remove <@роль>` — подключаться только для участников с ролью"
+
+	parts := strings.Fields(m.Content)
+	if len(parts) < 2 {
+		s.ChannelMessageSendComplex(textChannelID, &discordgo.MessageSend{
+			Content: describeAutoConnectRules(rules) + "\n\n" + usage,
+			// Don't ping the listed roles
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		return
+	}
+
+	if len(parts) < 3 {
+		s.ChannelMessageSend(textChannelID, usage)
+		return
+	}
+
+	switch strings.ToLower(parts[1]) {
+	case "min":
+		min, err := strconv.Atoi(parts[2])
+		if err != nil || min < 1 {
+			s.ChannelMessageSend(textChannelID, "Минимум слушателей должен быть числом не меньше 1.")
+			return
+		}
+		rules.MinListeners = min
+	case "days":
+		if strings.ToLower(parts[2]) == "all" {
+			rules.Days = nil
+			break
+		}
+		var days []int
+		for _, name := range strings.Split(strings.ToLower(parts[2]), ",") {
+			day, exists := weekdayNames[strings.TrimSpace(name)]
+			if !exists {
+				s.ChannelMessageSend(textChannelID, fmt.Sprintf("Неизвестный день недели `%s`. Используйте пн,вт,ср,чт,пт,сб,вс.", name))
+				return
+			}
+			days = append(days, int(day))
+		}
+		rules.Days = days
+	case "hours":
+		if strings.ToLower(parts[2]) == "all" {
+			rules.From, rules.To = "", ""
+			break
+		}
+		from, to, found := strings.Cut(parts[2], "-")
+		if !found {
+			s.ChannelMessageSend(textChannelID, "Укажите часы в виде `18:00-23:00`.")
+			return
+		}
+		if _, err := radio.ParseClock(from); err != nil {
+			s.ChannelMessageSend(textChannelID, "Укажите часы в виде `18:00-23:00`.")
+			return
+		}
+		if _, err := radio.ParseClock(to); err != nil {
+			s.ChannelMessageSend(textChannelID, "Укажите часы в виде `18:00-23:00`.")
+			return
+		}
+		rules.From, rules.To = from, to
+	case "timezone":
+		if _, err := time.LoadLocation(parts[2]); err != nil {
+			s.ChannelMessageSend(textChannelID, fmt.Sprintf("Неизвестный часовой пояс `%s`. Пример: `Europe/Moscow`.", parts[2]))
+			return
+		}
+		rules.Timezone = parts[2]
+	case "role":
+		if len(parts) < 4 {
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+		roleID := parseMentionID(parts[3])
+		switch strings.ToLower(parts[2]) {
+		case "add":
+			known := false
+			for _, id := range rules.TriggerRoles {
+				known = known || id == roleID
+			}
+			if !known {
+				rules.TriggerRoles = append(rules.TriggerRoles, roleID)
+			}
+		case "remove":
+			roles := rules.TriggerRoles[:0]
+			for _, id := range rules.TriggerRoles {
+				if id != roleID {
+					roles = append(roles, id)
+				}
+			}
+			rules.TriggerRoles = roles
+		default:
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+	default:
+		s.ChannelMessageSend(textChannelID, usage)
+		return
+	}
+
+	state.SetAutoConnectRules(rules)
+	if !b.saveCommandState(s, textChannelID, guildID) {
+		return
+	}
+
+	s.ChannelMessageSendComplex(textChannelID, &discordgo.MessageSend{
+		Content:         "✅ Правила сохранены\n" + describeAutoConnectRules(rules),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+}
+
+// describeAutoConnectRules formats the auto-connect rules for users
+func describeAutoConnectRules(rules radio.AutoConnectRules) string {
+	days := "каждый день"
+	if len(rules.Days) > 0 {
+		names := make([]string, 0, len(rules.Days))
+		for _, day := range rules.Days {
+			names = append(names, weekdayShortNames[day%7])
+		}
+		days = strings.Join(names, ", ")
+	}
+
+	hours := "круглосуточно"
+	if rules.From != "" && rules.To != "" {
+		hours = rules.From + "–" + rules.To
+	}
+
+	timezone := rules.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+
+	roles := "любые"
+	if len(rules.TriggerRoles) > 0 {
+		mentions := make([]string, 0, len(rules.TriggerRoles))
+		for _, id := range rules.TriggerRoles {
+			mentions = append(mentions, "<@&"+id+">")
+		}
+		roles = strings.Join(mentions, ", ")
+	}
+
+	return fmt.Sprintf("Минимум слушателей: **%d**\nДни: **%s**\nЧасы: **%s** (%s)\nРоли: %s",
+		rules.RequiredListeners(), days, hours, timezone, roles)
+}
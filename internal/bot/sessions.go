@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/ankogit/4duk-discord-bot/internal/radio"
+)
+
+// recordSession persists the session playing in a channel, so it survives a restart
+// Moving between channels keeps who started the session
+func (b *Bot) recordSession(guildID, channelID, startedBy string) {
+	state := b.radioManager.GetOrCreate(guildID)
+	if !state.MoveSession(channelID) {
+		state.StartSession(radio.Session{
+			ChannelID: channelID,
+			StartedBy: startedBy,
+			StartedAt: time.Now(),
+		})
+	}
+	b.saveState(guildID)
+}
+
+// endSession forgets the persisted session once the radio stops on purpose
+func (b *Bot) endSession(guildID string) {
+	if b.radioManager.GetOrCreate(guildID).EndSession() {
+		b.saveState(guildID)
+	}
+}
+
+// restoreSessions resumes the sessions that were playing before a restart
+// Guilds are restored one by one with a delay, so a restart doesn't cause a connect storm
+func (b *Bot) restoreSessions() {
+	defer b.wg.Done()
+
+	for _, guildID := range b.radioManager.GetAllGuildIDs() {
+		state, exists := b.radioManager.Get(guildID)
+		if !exists || state.GetSession() == nil {
+			continue
+		}
+
+		select {
+		case <-time.After(b.cfg().RestoreStagger):
+		case <-b.ctx.Done():
+			return
+		}
+
+		b.restoreSession(guildID)
+	}
+}
+
+// restoreSession resumes a guild's persisted session if listeners are still in its channel
+func (b *Bot) restoreSession(guildID string) {
+	defer b.recoverGuild(guildID, "restore")
+
+	state := b.radioManager.GetOrCreate(guildID)
+	session := state.GetSession()
+	if session == nil || state.IsActive() {
+		return
+	}
+
+	// Voice states arrive with the guild create after Ready
+	if !b.waitForGuild(guildID, b.cfg().GuildAvailableTimeout) {
+		b.log(guildID).Warn("Guild unavailable, not restoring session")
+		return
+	}
+
+	if userCount := b.countUsersInChannelFromState(guildID, session.ChannelID); userCount == 0 {
+		b.log(guildID).Infof("No listeners left in channel %s, dropping session", session.ChannelID)
+		b.endSession(guildID)
+		return
+	}
+
+	if err := b.checkVoiceJoin(guildID, session.ChannelID); err != nil {
+		b.log(guildID).WithError(err).Warnf("Can't rejoin channel %s, dropping session", session.ChannelID)
+		b.endSession(guildID)
+		return
+	}
+
+	if !state.BeginConnect() {
+		b.log(guildID).Info("Connect already in progress, skipping restore")
+		return
+	}
+	defer state.EndConnect()
+
+	b.log(guildID).Infof("Resuming session in channel %s started by %s at %s", session.ChannelID, session.StartedBy, session.StartedAt.Format(time.RFC3339))
+	if err := b.connectAndPlay(guildID, session.ChannelID, session.StartedBy); err != nil {
+		b.log(guildID).WithError(err).Error("Failed to resume session")
+	}
+}
+
+// waitForGuild waits until a guild is available in the session state
+func (b *Bot) waitForGuild(guildID string, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(b.cfg().GuildAvailablePoll)
+	defer ticker.Stop()
+
+	for {
+		if guild, err := b.session.State.Guild(guildID); err == nil && !guild.Unavailable {
+			return true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return false
+		case <-b.ctx.Done():
+			return false
+		}
+	}
+}
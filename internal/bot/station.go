@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/ankogit/4duk-discord-bot/internal/config"
+	"github.com/ankogit/4duk-discord-bot/internal/radio"
+)
+
+// handleStation handles the !station command
+// Only server admins may change the station: the bot fetches whatever URL it's given
+// Stations are shown without credentials, the channel may be public
+func (b *Bot) handleStation(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := m.GuildID
+	textChannelID := m.ChannelID
+
+	usage := "Использование: `!station <ссылка на поток>` или `!station default`"
+
+	parts := strings.Fields(m.Content)
+	if len(parts) < 2 {
+		source := "свою"
+		if b.radioManager.GetOrCreate(guildID).GetStation() == "" {
+			source = "по умолчанию"
+		}
+		s.ChannelMessageSend(textChannelID, fmt.Sprintf("Станция: <%s> (%s)\n\n%s", config.RedactURL(b.station(guildID)), source, usage))
+		return
+	}
+
+	if !b.isGuildAdmin(s, m) {
+		s.ChannelMessageSend(textChannelID, "Менять станцию могут только администраторы сервера (право **Управлять сервером**).")
+		return
+	}
+
+	station := parts[1]
+	if strings.EqualFold(station, "default") {
+		station = ""
+	}
+	if err := b.setStation(guildID, station); err != nil {
+		s.ChannelMessageSend(textChannelID, actionFailureMessage(err, usage))
+		return
+	}
+	s.ChannelMessageSend(textChannelID, fmt.Sprintf("✅ Станция: <%s>", config.RedactURL(b.station(guildID))))
+}
+
+// handleVolume handles the !volume command
+func (b *Bot) handleVolume(s *discordgo.Session, m *discordgo.MessageCreate) {
+	guildID := m.GuildID
+	textChannelID := m.ChannelID
+	state := b.radioManager.GetOrCreate(guildID)
+
+	usage := fmt.Sprintf("Использование: `!volume <0-%d>` или `!volume default`", radio.MaxVolume)
+
+	parts := strings.Fields(m.Content)
+	if len(parts) < 2 {
+		source := "своя"
+		if !state.HasVolume() {
+			source = "по умолчанию"
+		}
+		s.ChannelMessageSend(textChannelID, fmt.Sprintf("Громкость: **%d%%** (%s)\n\n%s", state.GetVolume(), source, usage))
+		return
+	}
+
+	percent := -1
+	if !strings.EqualFold(parts[1], "default") {
+		var err error
+		percent, err = strconv.Atoi(strings.TrimSuffix(parts[1], "%"))
+		if err != nil || percent < 0 {
+			s.ChannelMessageSend(textChannelID, usage)
+			return
+		}
+	}
+	if err := b.setVolume(guildID, percent); err != nil {
+		s.ChannelMessageSend(textChannelID, actionFailureMessage(err, usage))
+		return
+	}
+	s.ChannelMessageSend(textChannelID, fmt.Sprintf("✅ Громкость: **%d%%**", state.GetVolume()))
+}
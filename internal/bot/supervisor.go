@@ -0,0 +1,209 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// crashLog remembers recent crashes by guild, "" for work shared by all guilds
+type crashLog struct {
+	mu      sync.Mutex
+	crashes map[string][]time.Time
+}
+
+func newCrashLog() *crashLog {
+	return &crashLog{crashes: make(map[string][]time.Time)}
+}
+
+// record adds a crash and returns the number of crashes within window, this one included
+func (c *crashLog) record(guildID string, at time.Time, window time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	recent := c.crashes[guildID][:0]
+	for _, t := range c.crashes[guildID] {
+		if at.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, at)
+	c.crashes[guildID] = recent
+	return len(recent)
+}
+
+// goGuild runs work of a guild in the background under its supervisor, see recoverGuild
+func (b *Bot) goGuild(guildID, task string, work func()) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer b.recoverGuild(guildID, task)
+		work()
+	}()
+}
+
+// recoverGuild recovers a panic in work of a guild, it must be deferred directly
+func (b *Bot) recoverGuild(guildID, task string) {
+	if r := recover(); r != nil {
+		b.guildCrashed(guildID, task, r, debug.Stack())
+	}
+}
+
+// guildCrashed handles a panic recovered in work of a guild
+// Only the guild's voice state is reset, other guilds keep playing
+// The radio is reconnected unless the guild crashed too often recently
+func (b *Bot) guildCrashed(guildID, task string, r interface{}, stack []byte) {
+	cfg := b.cfg()
+	crashes := b.crashLog.record(guildID, time.Now(), cfg.CrashWindow)
+	b.metrics.Panic(guildID, task)
+	b.log(guildID).WithFields(logrus.Fields{
+		"task":         task,
+		"panic":        fmt.Sprint(r),
+		"stack":        string(stack),
+		"crashes":      crashes,
+		"crash_report": b.reportCrash(guildID, task, r, stack),
+	}).Error("Panic in guild work, resetting its voice connection")
+
+	b.resetGuildVoice(guildID)
+
+	state, exists := b.radioManager.Get(guildID)
+	if !exists || !state.IsActive() {
+		return
+	}
+	if crashes >= cfg.GuildMaxCrashes {
+		b.log(guildID).Errorf("Guild crashed %d times within %v, stopping the radio", crashes, cfg.CrashWindow)
+		state.MarkFailing()
+		b.leaveVoice(guildID)
+		return
+	}
+	b.goGuild(guildID, "reconnect", func() { b.reconnectRadio(guildID) })
+}
+
+// resetGuildVoice drops the stream and voice connection of a guild, keeping its settings
+func (b *Bot) resetGuildVoice(guildID string) {
+	if state, exists := b.radioManager.Get(guildID); exists {
+		state.StopStream()
+	}
+
+	if vc, exists := b.session.VoiceConnections[guildID]; exists {
+		// Remove from map first to prevent Kill() panic
+		delete(b.session.VoiceConnections, guildID)
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					b.log(guildID).Debugf("Panic during disconnect (ignored): %v", r)
+				}
+			}()
+			_ = vc.Disconnect(context.Background())
+		}()
+	}
+
+	b.encoderPool.Remove(guildID)
+}
+
+// supervised wraps an event handler so a panic in it doesn't take down the process
+// A panic handling an event of a guild is a crash of that guild, see guildCrashed
+// Other events are shared by all guilds, so their repeated panics are escalated to a restart of the bot
+func supervised[T any](b *Bot, handler func(*discordgo.Session, T)) func(*discordgo.Session, T) {
+	return func(s *discordgo.Session, event T) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+
+			task := fmt.Sprintf("%T", event)
+			stack := debug.Stack()
+			if guildID := eventGuildID(event); guildID != "" {
+				b.guildCrashed(guildID, task, r, stack)
+				return
+			}
+
+			cfg := b.cfg()
+			crashes := b.crashLog.record("", time.Now(), cfg.CrashWindow)
+			b.metrics.Panic("", task)
+			b.logger.WithFields(logrus.Fields{
+				"task":         task,
+				"panic":        fmt.Sprint(r),
+				"stack":        string(stack),
+				"crashes":      crashes,
+				"crash_report": b.reportCrash("", task, r, stack),
+			}).Error("Panic in event handler")
+
+			if crashes >= cfg.SharedMaxCrashes {
+				b.fail(fmt.Errorf("event handlers panicked %d times within %v, last: %v", crashes, cfg.CrashWindow, r))
+			}
+		}()
+		handler(s, event)
+	}
+}
+
+// eventGuildID returns the guild an event is about, empty if it isn't about one
+func eventGuildID(event interface{}) string {
+	switch e := event.(type) {
+	case *discordgo.MessageCreate:
+		if e.Message != nil {
+			return e.GuildID
+		}
+	case *discordgo.VoiceStateUpdate:
+		if e.VoiceState != nil {
+			return e.GuildID
+		}
+	case *discordgo.ChannelUpdate:
+		if e.Channel != nil {
+			return e.GuildID
+		}
+	case *discordgo.ChannelDelete:
+		if e.Channel != nil {
+			return e.GuildID
+		}
+	case *discordgo.GuildCreate:
+		if e.Guild != nil {
+			return e.ID
+		}
+	case *discordgo.GuildDelete:
+		if e.Guild != nil {
+			return e.ID
+		}
+	case *discordgo.GuildRoleUpdate:
+		if e.GuildRole != nil {
+			return e.GuildID
+		}
+	case *discordgo.GuildRoleDelete:
+		return e.GuildID
+	case *discordgo.GuildMemberAdd:
+		if e.Member != nil {
+			return e.GuildID
+		}
+	case *discordgo.GuildMemberUpdate:
+		if e.Member != nil {
+			return e.GuildID
+		}
+	case *discordgo.GuildMemberRemove:
+		if e.Member != nil {
+			return e.GuildID
+		}
+	case *discordgo.GuildMembersChunk:
+		return e.GuildID
+	}
+	return ""
+}
+
+// fail reports that the bot can't continue and has to be restarted, see Failed
+func (b *Bot) fail(err error) {
+	select {
+	case b.failed <- err:
+	default:
+		// A failure is already pending
+	}
+}
+
+// Failed reports failures of work shared by all guilds, after which the bot has to be restarted
+func (b *Bot) Failed() <-chan error {
+	return b.failed
+}
@@ -2,13 +2,106 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ankogit/4duk-discord-bot/internal/audio"
+	"github.com/ankogit/4duk-discord-bot/internal/config"
 )
 
+// voiceJoinError is returned when a pre-flight check shows that joining a voice channel would fail
+type voiceJoinError struct {
+	// Reason is a user-facing explanation of what has to be fixed
+	Reason string
+}
+
+func (e *voiceJoinError) Error() string {
+	return "voice join pre-flight check failed: " + e.Reason
+}
+
+// joinFailureMessage formats a failed join for users, explaining pre-flight failures
+func joinFailureMessage(err error, fallback string) string {
+	var joinErr *voiceJoinError
+	if errors.As(err, &joinErr) {
+		return "Не могу подключиться: " + joinErr.Reason + "."
+	}
+	return fallback
+}
+
+// checkVoiceJoin verifies that the bot is able to join a voice channel
+// Checks the channel type, the bot's effective permissions and the channel user limit,
+// so users get an actionable answer instead of a join timeout
+func (b *Bot) checkVoiceJoin(guildID, channelID string) error {
+	channel, err := b.session.State.Channel(channelID)
+	if err != nil {
+		channel, err = b.session.Channel(channelID)
+		if err != nil {
+			return &voiceJoinError{Reason: "канал не найден или бот его не видит"}
+		}
+	}
+	if channel.GuildID != guildID {
+		return &voiceJoinError{Reason: "канал не найден на этом сервере"}
+	}
+
+	if channel.Type != discordgo.ChannelTypeGuildVoice {
+		return &voiceJoinError{Reason: fmt.Sprintf("**%s** не голосовой канал", channel.Name)}
+	}
+
+	botID := b.session.State.User.ID
+
+	// Already there, nothing can stop us
+	if botVS, err := b.session.State.VoiceState(guildID, botID); err == nil && botVS != nil && botVS.ChannelID == channelID {
+		return nil
+	}
+
+	perms, err := b.session.UserChannelPermissions(botID, channelID)
+	if err != nil {
+		// Let the join itself decide rather than refusing on incomplete data
+		b.log(guildID).WithError(err).Debugf("Failed to compute permissions for channel %s, skipping pre-flight check", channelID)
+		return nil
+	}
+
+	if missing := missingPermissionNames(perms); len(missing) > 0 {
+		return &voiceJoinError{Reason: fmt.Sprintf("у бота нет прав **%s** в канале **%s** — выдайте их роли бота или в настройках канала",
+			strings.Join(missing, ", "), channel.Name)}
+	}
+
+	// Move Members (or Administrator) lets the bot join channels that are full
+	canBypassLimit := perms&(discordgo.PermissionVoiceMoveMembers|discordgo.PermissionAdministrator) != 0
+	if channel.UserLimit > 0 && !canBypassLimit {
+		occupants := b.countOccupantsInChannel(guildID, channelID)
+		if occupants >= channel.UserLimit {
+			return &voiceJoinError{Reason: fmt.Sprintf("канал **%s** заполнен (%d/%d) — увеличьте лимит или выдайте боту право Move Members",
+				channel.Name, occupants, channel.UserLimit)}
+		}
+	}
+
+	return nil
+}
+
+// countOccupantsInChannel counts everyone in a voice channel, bots included, as the user limit does
+func (b *Bot) countOccupantsInChannel(guildID, channelID string) int {
+	guild, err := b.session.State.Guild(guildID)
+	if err != nil {
+		return 0
+	}
+
+	occupants := 0
+	for _, vs := range guild.VoiceStates {
+		if vs.ChannelID == channelID {
+			occupants++
+		}
+	}
+	return occupants
+}
+
 // connectToChannel connects to a voice channel
+// Callers run checkVoiceJoin first, before changing any state
 func (b *Bot) connectToChannel(s *discordgo.Session, guildID, channelID string) (*discordgo.VoiceConnection, error) {
 	// Check if already connected and ready
 	if vc, exists := s.VoiceConnections[guildID]; exists {
@@ -25,7 +118,7 @@ func (b *Bot) connectToChannel(s *discordgo.Session, guildID, channelID string)
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
-					b.logger.Debugf("[%s] Panic during disconnect (ignored): %v", guildID, r)
+					b.log(guildID).Debugf("Panic during disconnect (ignored): %v", r)
 				}
 			}()
 			_ = vc.Disconnect(context.Background())
@@ -38,14 +131,16 @@ func (b *Bot) connectToChannel(s *discordgo.Session, guildID, channelID string)
 	var err error
 
 	// Create context with timeout for connection
-	ctx, cancel := context.WithTimeout(b.ctx, 15*time.Second)
+	ctx, cancel := context.WithTimeout(b.ctx, b.cfg().VoiceJoinTimeout)
 	defer cancel()
 
-	// Try connecting up to 3 times with delays
-	for attempt := 0; attempt < 3; attempt++ {
+	joinStart := time.Now()
+
+	// Try connecting a few times with delays
+	for attempt := 0; attempt < b.cfg().VoiceJoinAttempts; attempt++ {
 		if attempt > 0 {
 			// Wait before retry
-			time.Sleep(time.Duration(attempt) * time.Second)
+			time.Sleep(time.Duration(attempt) * b.cfg().VoiceJoinRetryDelay)
 			// Disconnect any existing connection
 			if existing, exists := s.VoiceConnections[guildID]; exists {
 				// Remove from map first to prevent Kill() panic
@@ -54,12 +149,12 @@ func (b *Bot) connectToChannel(s *discordgo.Session, guildID, channelID string)
 				func() {
 					defer func() {
 						if r := recover(); r != nil {
-							b.logger.Debugf("[%s] Panic during disconnect (ignored): %v", guildID, r)
+							b.log(guildID).Debugf("Panic during disconnect (ignored): %v", r)
 						}
 					}()
 					_ = existing.Disconnect(context.Background())
 				}()
-				time.Sleep(500 * time.Millisecond)
+				time.Sleep(b.cfg().VoiceDisconnectSettle)
 			}
 		}
 
@@ -67,7 +162,7 @@ func (b *Bot) connectToChannel(s *discordgo.Session, guildID, channelID string)
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
-					b.logger.Warnf("[%s] Panic during ChannelVoiceJoin: %v", guildID, r)
+					b.log(guildID).Warnf("Panic during ChannelVoiceJoin: %v", r)
 					// Remove any bad connection from map
 					if badVC, exists := s.VoiceConnections[guildID]; exists {
 						delete(s.VoiceConnections, guildID)
@@ -87,19 +182,20 @@ func (b *Bot) connectToChannel(s *discordgo.Session, guildID, channelID string)
 			break
 		}
 
-		b.logger.Warnf("[%s] Voice join attempt %d failed: %v", guildID, attempt+1, err)
+		b.log(guildID).WithField("channel", channelID).WithError(err).Warnf("Voice join attempt %d failed", attempt+1)
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to join voice channel after 3 attempts: %w", err)
+		b.metrics.VoiceConnected(guildID, time.Since(joinStart), err)
+		return nil, fmt.Errorf("failed to join voice channel after %d attempts: %w", b.cfg().VoiceJoinAttempts, err)
 	}
 
 	// Wait for voice connection to be ready
 	// Check Ready status periodically
-	timeout := time.NewTimer(10 * time.Second)
+	timeout := time.NewTimer(b.cfg().VoiceReadyTimeout)
 	defer timeout.Stop()
 
-	ticker := time.NewTicker(100 * time.Millisecond)
+	ticker := time.NewTicker(b.cfg().VoiceReadyPoll)
 	defer ticker.Stop()
 
 	for {
@@ -108,12 +204,13 @@ func (b *Bot) connectToChannel(s *discordgo.Session, guildID, channelID string)
 			if vc.Status == discordgo.VoiceConnectionStatusReady {
 				// Wait a bit more for connection to fully stabilize
 				// This ensures the websocket is fully established
-				time.Sleep(500 * time.Millisecond)
+				time.Sleep(b.cfg().VoiceReadySettle)
 				// Double-check connection is still ready
 				if vc.Status != discordgo.VoiceConnectionStatusReady {
 					continue
 				}
-				b.logger.Infof("[%s] Connected to voice channel %s", guildID, channelID)
+				b.log(guildID).WithField("channel", channelID).Info("Connected to voice channel")
+				b.metrics.VoiceConnected(guildID, time.Since(joinStart), nil)
 				return vc, nil
 			}
 		case <-timeout.C:
@@ -123,7 +220,9 @@ func (b *Bot) connectToChannel(s *discordgo.Session, guildID, channelID string)
 				defer func() { recover() }()
 				_ = vc.Disconnect(context.Background())
 			}()
-			return nil, fmt.Errorf("timeout waiting for voice connection")
+			err := fmt.Errorf("timeout waiting for voice connection")
+			b.metrics.VoiceConnected(guildID, time.Since(joinStart), err)
+			return nil, err
 		case <-b.ctx.Done():
 			// Remove from map first to prevent Kill() panic
 			delete(s.VoiceConnections, guildID)
@@ -143,35 +242,121 @@ func (b *Bot) startRadio(vc *discordgo.VoiceConnection, guildID string) error {
 	}
 
 	state := b.radioManager.GetOrCreate(guildID)
+	state.ClearFailing()
 
-	// Create context for this stream
+	// Create context for this stream, replacing any stream still running
 	streamCtx, cancel := context.WithCancel(b.ctx)
+	streamID := state.StartStream(cancel)
 
 	// Start playing in a goroutine
-	b.wg.Add(1)
-	go func() {
-		defer b.wg.Done()
+	b.goGuild(guildID, "stream", func() {
 		defer cancel()
 
-		// Check if still active periodically
-		isActive := func() bool {
-			return state.IsActive()
-		}
-
-		err := b.streamer.Stream(streamCtx, vc, guildID, isActive)
+		station := b.station(guildID)
+		streamStart := time.Now()
+		err := b.streamer.Stream(streamCtx, vc, guildID, audio.StreamOptions{
+			Station:  station,
+			IsActive: state.IsActive,
+			IsMuted:  state.IsMuted,
+			Volume:   state.GetVolume,
+		})
 		if err != nil {
-			b.logger.WithError(err).Warnf("[%s] Stream ended", guildID)
+			b.log(guildID).WithFields(logrus.Fields{"channel": state.GetChannelID(), "station": config.RedactURL(station)}).
+				WithError(err).Warn("Stream ended")
 		}
 
-		// Trigger reconnect if still active
-		if state.IsActive() {
-			b.wg.Add(1)
-			go func() {
-				defer b.wg.Done()
+		// Trigger reconnect if still active and not replaced by a newer stream
+		if state.IsActive() && state.IsCurrentStream(streamID) {
+			var upstreamErr *audio.UpstreamError
+			played := time.Since(streamStart)
+			b.goGuild(guildID, "reconnect", func() {
+				// The station failed rather than the voice connection, keep the connection
+				if errors.As(err, &upstreamErr) {
+					b.restartStream(guildID, streamID, upstreamErr, played)
+					return
+				}
 				b.reconnectRadio(guildID)
-			}()
+			})
+		}
+	})
+
+	return nil
+}
+
+// station returns the station played in a guild, its own or RADIO_URL
+func (b *Bot) station(guildID string) string {
+	if state, exists := b.radioManager.Get(guildID); exists {
+		if station := state.GetStation(); station != "" {
+			return station
 		}
-	}()
+	}
+	return b.cfg().RadioURL
+}
+
+// leaveVoice stops the radio in a guild and disconnects from voice
+func (b *Bot) leaveVoice(guildID string) {
+	state := b.radioManager.GetOrCreate(guildID)
+	state.SetActive(false)
+	state.ResetReconnectAttempts()
+	state.StopIdle()
+
+	if vc, exists := b.session.VoiceConnections[guildID]; exists {
+		// Remove from map first to prevent Kill() panic
+		delete(b.session.VoiceConnections, guildID)
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					b.log(guildID).Debugf("Panic during disconnect (ignored): %v", r)
+				}
+			}()
+			_ = vc.Disconnect(b.ctx)
+		}()
+	}
+
+	// Cleanup encoder
+	b.encoderPool.Remove(guildID)
+
+	b.endSession(guildID)
+}
+
+// connectAndPlay marks the radio active in a channel, connects and starts streaming
+// On failure the radio is marked inactive again, callers run checkVoiceJoin first
+func (b *Bot) connectAndPlay(guildID, channelID, startedBy string) error {
+	state := b.radioManager.GetOrCreate(guildID)
+
+	log := b.log(guildID).WithFields(logrus.Fields{"channel": channelID, "user": startedBy})
+
+	// Set state
+	log.Debug("Setting state: active=true")
+	state.SetActive(true)
+	state.SetChannelID(channelID)
+	state.ResetReconnectAttempts()
+	state.StopIdle()
+
+	// Connect to channel
+	log.Info("Connecting to channel...")
+	vc, err := b.connectToChannel(b.session, guildID, channelID)
+	if err != nil {
+		state.SetActive(false)
+		b.endSession(guildID)
+		return err
+	}
+
+	if vc == nil {
+		state.SetActive(false)
+		b.endSession(guildID)
+		return fmt.Errorf("voice connection is nil after connect")
+	}
+
+	log.Debug("Successfully connected to channel, starting radio...")
+	// Start radio
+	if err := b.startRadio(vc, guildID); err != nil {
+		state.SetActive(false)
+		b.endSession(guildID)
+		return fmt.Errorf("failed to start radio: %w", err)
+	}
 
+	b.recordSession(guildID, channelID, startedBy)
+	log.WithField("station", config.RedactURL(b.station(guildID))).Info("Radio started successfully")
 	return nil
 }
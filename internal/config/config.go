@@ -1,8 +1,16 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -11,33 +19,428 @@ import (
 // Config holds all configuration for the bot
 type Config struct {
 	DiscordToken          string
+	MembersIntent         string        // Whether the privileged Server Members intent is requested: on or off
+	MemberCacheTTL        time.Duration // How long a member is trusted without the members intent
+	MemberRequestInterval time.Duration // How often the same missing member may be requested from the gateway
 	RadioURL              string
 	MaxReconnectAttempts  int
 	ReconnectBackoffBase  time.Duration
+	EmptyRecheckDelay     time.Duration // Wait before a channel that looks empty is checked again on reconnect
+	StreamHealthyAfter    time.Duration // How long a stream must play before its restart attempts are forgotten
 	VoiceCheckInterval    time.Duration
+	VoiceJoinTimeout      time.Duration // Limit of all attempts to join a voice channel
+	VoiceJoinAttempts     int
+	VoiceJoinRetryDelay   time.Duration // Delay before the second attempt, growing linearly
+	VoiceDisconnectSettle time.Duration // Wait after dropping a failed connection before joining again
+	VoiceReadyTimeout     time.Duration // How long a joined voice connection may take to become ready
+	VoiceReadyPoll        time.Duration // How often a joined voice connection is checked for being ready
+	VoiceReadySettle      time.Duration // Wait after the connection is ready, so its websocket is fully established
+	StreamStabilizeDelay  time.Duration // Wait before the first frame is sent
+	StreamSendTimeout     time.Duration // How long sending one frame to Discord may take
+	StreamStallThreshold  time.Duration // How long a read of one frame may take before the station counts as stalled
+	IdleGracePeriod       time.Duration // Default time to stay in an empty channel before leaving
+	AutoConnectDebounce   time.Duration // Delay before auto-connecting, so quick join/leave doesn't connect
+	FollowTimeout         time.Duration // How long to wait paused after the followed user disconnects
+	RestoreStagger        time.Duration // Delay between resuming sessions after a restart
+	GuildAvailableTimeout time.Duration // How long a restore waits for a guild to arrive after Ready
+	GuildAvailablePoll    time.Duration // How often the guild is looked for meanwhile
+	ShutdownTimeout       time.Duration // How long shutdown waits for running work
+	DataDir               string        // Directory for persistent data
+	StorageBackend        string        // How guild configuration is stored: json, journal or memory
+	GuildRetention        string        // What happens to the configuration of a guild the bot left: archive or delete
+	GuildArchiveTTL       time.Duration // How long archived guild configuration is kept
+	GuildSweepInterval    time.Duration // How often saved guilds are reconciled with the guilds the bot is in
+	HTTPAddr              string        // Address of the HTTP listener for metrics, disabled if empty
+	MetricsGuildLabels    string        // Guild label of metrics: all, none or a comma-separated list of guild IDs
+	FailedGuildThreshold  time.Duration // How long a guild may stay without a voice connection before the bot is not ready
+	LogLevel              string        // Minimum level of log messages: debug, info, warn or error
+	LogFormat             string        // Format of log messages: text or json
+	AdminAddr             string        // Address of the admin API listener
+	AdminToken            string        // Bearer token of the admin API, the API is disabled if empty
+	ControlSocket         string        // Path of the Unix socket used by `bot ctl`
+	CrashWindow           time.Duration // How far back crashes are counted
+	GuildMaxCrashes       int           // Crashes of a guild's work within CrashWindow before its radio is stopped
+	SharedMaxCrashes      int           // Panics of event handlers outside any guild within CrashWindow before the bot is restarted
+	MaxRestarts           int           // Restarts of the whole bot within CrashWindow before the process exits
+	GatewayTimeout        time.Duration // How long the gateway may stay disconnected before the bot is restarted
+	CrashReports          int           // Crash reports kept in DataDir/crashes, none are written if 0
+
+	File    string            // Config file the settings were read from, empty if none
+	sources map[string]string // Where each setting came from, by key
+}
+
+// Where a setting came from
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+)
+
+// setting is one tunable, read from the config file and the environment
+type setting struct {
+	key      string      // Key in the config file, "section.name"
+	env      string      // Environment variable overriding the file
+	field    interface{} // Pointer to the Config field: *string, *int or *time.Duration
+	def      string      // Default, written like the environment variable
+	oneOf    []string    // Allowed values of a string
+	positive bool        // Numbers and durations must be above zero, otherwise at least zero
+	secret   bool        // Masked in dumps
+	url      bool        // Credentials and query masked in dumps, see RedactURL
+	restart  bool        // Only read at startup, a reload doesn't change it
+	check    func(value string) error
 }
 
-// Load loads configuration from environment variables
+// settings lists every setting of c, in the order of the config file
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "discord.token", env: "DISCORD_TOKEN", field: &c.DiscordToken, secret: true, restart: true},
+		{key: "discord.members_intent", env: "MEMBERS_INTENT", field: &c.MembersIntent, def: "off", oneOf: []string{"on", "off"}, restart: true},
+		{key: "discord.member_cache_ttl", env: "MEMBER_CACHE_TTL", field: &c.MemberCacheTTL, def: "10m", positive: true, restart: true},
+		{key: "discord.member_request_interval", env: "MEMBER_REQUEST_INTERVAL", field: &c.MemberRequestInterval, def: "1m", restart: true},
+		{key: "radio.url", env: "RADIO_URL", field: &c.RadioURL, def: "http://radio.4duk.ru/4duk128.mp3", url: true, check: checkRadioURL},
+
+		{key: "reconnect.max_attempts", env: "MAX_RECONNECT_ATTEMPTS", field: &c.MaxReconnectAttempts, def: "5", positive: true},
+		{key: "reconnect.backoff_base", env: "RECONNECT_BACKOFF_BASE", field: &c.ReconnectBackoffBase, def: "2s", positive: true},
+		{key: "reconnect.empty_recheck_delay", env: "RECONNECT_EMPTY_RECHECK_DELAY", field: &c.EmptyRecheckDelay, def: "500ms"},
+		{key: "reconnect.stream_healthy_after", env: "STREAM_HEALTHY_AFTER", field: &c.StreamHealthyAfter, def: "1m"},
+
+		{key: "voice.check_interval", env: "VOICE_CHECK_INTERVAL", field: &c.VoiceCheckInterval, def: "20s", positive: true, restart: true},
+		{key: "voice.join_timeout", env: "VOICE_JOIN_TIMEOUT", field: &c.VoiceJoinTimeout, def: "15s", positive: true},
+		{key: "voice.join_attempts", env: "VOICE_JOIN_ATTEMPTS", field: &c.VoiceJoinAttempts, def: "3", positive: true},
+		{key: "voice.join_retry_delay", env: "VOICE_JOIN_RETRY_DELAY", field: &c.VoiceJoinRetryDelay, def: "1s"},
+		{key: "voice.disconnect_settle", env: "VOICE_DISCONNECT_SETTLE", field: &c.VoiceDisconnectSettle, def: "500ms"},
+		{key: "voice.ready_timeout", env: "VOICE_READY_TIMEOUT", field: &c.VoiceReadyTimeout, def: "10s", positive: true},
+		{key: "voice.ready_poll", env: "VOICE_READY_POLL", field: &c.VoiceReadyPoll, def: "100ms", positive: true},
+		{key: "voice.ready_settle", env: "VOICE_READY_SETTLE", field: &c.VoiceReadySettle, def: "500ms"},
+
+		{key: "stream.stabilize_delay", env: "STREAM_STABILIZE_DELAY", field: &c.StreamStabilizeDelay, def: "1s"},
+		{key: "stream.send_timeout", env: "STREAM_SEND_TIMEOUT", field: &c.StreamSendTimeout, def: "100ms", positive: true},
+		{key: "stream.stall_threshold", env: "STREAM_STALL_THRESHOLD", field: &c.StreamStallThreshold, def: "2s", positive: true},
+
+		{key: "guild.idle_grace_period", env: "IDLE_GRACE_PERIOD", field: &c.IdleGracePeriod, def: "30s"},
+		{key: "guild.auto_connect_debounce", env: "AUTO_CONNECT_DEBOUNCE", field: &c.AutoConnectDebounce, def: "3s"},
+		{key: "guild.follow_timeout", env: "FOLLOW_TIMEOUT", field: &c.FollowTimeout, def: "2m"},
+		{key: "guild.restore_stagger", env: "RESTORE_STAGGER", field: &c.RestoreStagger, def: "2s"},
+		{key: "guild.available_timeout", env: "GUILD_AVAILABLE_TIMEOUT", field: &c.GuildAvailableTimeout, def: "30s", positive: true},
+		{key: "guild.available_poll", env: "GUILD_AVAILABLE_POLL", field: &c.GuildAvailablePoll, def: "500ms", positive: true},
+		{key: "guild.retention", env: "GUILD_RETENTION", field: &c.GuildRetention, def: "archive", oneOf: []string{"archive", "delete"}},
+		{key: "guild.archive_ttl", env: "GUILD_ARCHIVE_TTL", field: &c.GuildArchiveTTL, def: "720h"},
+		{key: "guild.sweep_interval", env: "GUILD_SWEEP_INTERVAL", field: &c.GuildSweepInterval, def: "1h", positive: true, restart: true},
+
+		{key: "storage.data_dir", env: "DATA_DIR", field: &c.DataDir, def: "data", restart: true},
+		{key: "storage.backend", env: "STORAGE_BACKEND", field: &c.StorageBackend, def: "json", oneOf: []string{"json", "journal", "memory"}, restart: true},
+
+		{key: "http.addr", env: "HTTP_ADDR", field: &c.HTTPAddr, restart: true},
+		{key: "http.metrics_guild_labels", env: "METRICS_GUILD_LABELS", field: &c.MetricsGuildLabels, def: "all"},
+		{key: "http.failed_guild_threshold", env: "FAILED_GUILD_THRESHOLD", field: &c.FailedGuildThreshold, def: "5m"},
+
+		{key: "log.level", env: "LOG_LEVEL", field: &c.LogLevel, def: "info", oneOf: []string{"debug", "info", "warn", "error"}},
+		{key: "log.format", env: "LOG_FORMAT", field: &c.LogFormat, def: "text", oneOf: []string{"text", "json"}, restart: true},
+
+		{key: "admin.addr", env: "ADMIN_ADDR", field: &c.AdminAddr, def: "127.0.0.1:8081", restart: true},
+		{key: "admin.token", env: "ADMIN_TOKEN", field: &c.AdminToken, secret: true, restart: true},
+		{key: "admin.control_socket", env: "CONTROL_SOCKET", field: &c.ControlSocket, restart: true},
+
+		{key: "supervisor.crash_window", env: "CRASH_WINDOW", field: &c.CrashWindow, def: "10m", positive: true},
+		{key: "supervisor.guild_max_crashes", env: "GUILD_MAX_CRASHES", field: &c.GuildMaxCrashes, def: "3", positive: true},
+		{key: "supervisor.shared_max_crashes", env: "SHARED_MAX_CRASHES", field: &c.SharedMaxCrashes, def: "3", positive: true},
+		{key: "supervisor.max_restarts", env: "MAX_RESTARTS", field: &c.MaxRestarts, def: "5", positive: true},
+		{key: "supervisor.gateway_timeout", env: "GATEWAY_TIMEOUT", field: &c.GatewayTimeout, def: "5m", positive: true},
+		{key: "supervisor.crash_reports", env: "CRASH_REPORTS", field: &c.CrashReports, def: "20"},
+
+		{key: "shutdown.timeout", env: "SHUTDOWN_TIMEOUT", field: &c.ShutdownTimeout, def: "10s", positive: true},
+	}
+}
+
+// Load loads configuration from the config file named by CONFIG_FILE, if any,
+// and environment variables, which override the file
+// All invalid settings are reported at once
 func Load() (*Config, error) {
+	cfg, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DiscordToken == "" {
+		return nil, fmt.Errorf("DISCORD_TOKEN not set in environment or discord.token in the config file")
+	}
+	return cfg, nil
+}
+
+// LoadOffline loads the configuration like Load without requiring the Discord token,
+// for commands that don't connect to Discord
+func LoadOffline() (*Config, error) {
+	return load()
+}
+
+// load reads the configuration without requiring the Discord token
+func load() (*Config, error) {
 	// Try to load .env file (optional)
 	_ = godotenv.Load()
 
-	discordToken := os.Getenv("DISCORD_TOKEN")
-	if discordToken == "" {
-		return nil, fmt.Errorf("DISCORD_TOKEN not set in environment")
+	cfg := &Config{
+		File:    os.Getenv("CONFIG_FILE"),
+		sources: make(map[string]string),
+	}
+
+	file := map[string]string{}
+	if cfg.File != "" {
+		var err error
+		if file, err = readFile(cfg.File); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	known := make(map[string]bool)
+	for _, s := range cfg.settings() {
+		known[s.key] = true
+
+		value, source, name, where := s.def, SourceDefault, s.key, ""
+		if v, exists := file[s.key]; exists {
+			value, source, where = v, SourceFile, " in "+cfg.File
+		}
+		if v := os.Getenv(s.env); v != "" {
+			value, source, name, where = v, SourceEnv, s.env, ""
+		}
+
+		if err := s.set(value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q%s: %w", name, value, where, err))
+			continue
+		}
+		cfg.sources[s.key] = source
+	}
+
+	for key := range file {
+		if !known[key] {
+			errs = append(errs, fmt.Errorf("unknown setting %s in %s", key, cfg.File))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+		return nil, errors.Join(errs...)
+	}
+
+	if cfg.ControlSocket == "" {
+		cfg.ControlSocket = filepath.Join(cfg.DataDir, "control.sock")
+	}
+	return cfg, nil
+}
+
+// set parses and validates a value, then stores it in the setting's field
+func (s setting) set(value string) error {
+	switch field := s.field.(type) {
+	case *string:
+		if len(s.oneOf) > 0 && !contains(s.oneOf, value) {
+			return fmt.Errorf("expected %s", alternatives(s.oneOf))
+		}
+		if s.check != nil && value != "" {
+			if err := s.check(value); err != nil {
+				return err
+			}
+		}
+		*field = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || (s.positive && n == 0) {
+			return fmt.Errorf("expected a %s whole number", s.sign())
+		}
+		*field = n
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 || (s.positive && d == 0) {
+			return fmt.Errorf("expected a %s duration like 30s", s.sign())
+		}
+		*field = d
+	default:
+		panic(fmt.Sprintf("config: unsupported type of %s", s.key))
 	}
+	return nil
+}
 
-	radioURL := os.Getenv("RADIO_URL")
-	if radioURL == "" {
-		radioURL = "http://radio.4duk.ru/4duk128.mp3"
+// text returns the value of the setting's field, written like the environment variable
+func (s setting) text() string {
+	switch field := s.field.(type) {
+	case *string:
+		return *field
+	case *int:
+		return strconv.Itoa(*field)
+	case *time.Duration:
+		return field.String()
 	}
+	return ""
+}
 
-	return &Config{
-		DiscordToken:         discordToken,
-		RadioURL:             radioURL,
-		MaxReconnectAttempts:  5,
-		ReconnectBackoffBase:  2 * time.Second,
-		VoiceCheckInterval:   20 * time.Second,
-	}, nil
+// copyFrom stores the value of the same setting of another Config
+func (s setting) copyFrom(other setting) {
+	switch field := s.field.(type) {
+	case *string:
+		*field = *other.field.(*string)
+	case *int:
+		*field = *other.field.(*int)
+	case *time.Duration:
+		*field = *other.field.(*time.Duration)
+	}
 }
 
+// sign describes the numbers a setting accepts
+func (s setting) sign() string {
+	if s.positive {
+		return "positive"
+	}
+	return "non-negative"
+}
+
+// readFile reads a JSON config file into values by "section.name" key,
+// written like environment variables
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var sections map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line := 1 + bytes.Count(data[:syntaxErr.Offset], []byte("\n"))
+			return nil, fmt.Errorf("failed to parse %s line %d: %w", path, line, err)
+		}
+		return nil, fmt.Errorf("failed to parse %s: expected sections of settings, like {\"voice\": {\"join_timeout\": \"15s\"}}", path)
+	}
+
+	values := make(map[string]string)
+	for section, settings := range sections {
+		for name, raw := range settings {
+			key := section + "." + name
+			var text string
+			if err := json.Unmarshal(raw, &text); err != nil {
+				// Numbers are written as they are
+				text = string(bytes.TrimSpace(raw))
+				if strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[") || text == "null" {
+					return nil, fmt.Errorf("invalid %s in %s: expected a string or a number", key, path)
+				}
+			}
+			values[key] = text
+		}
+	}
+	return values, nil
+}
+
+// checkRadioURL accepts the URLs a radio station can be streamed from
+func checkRadioURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("expected an http or https URL")
+	}
+	return nil
+}
+
+// RedactURL masks the credentials and query of a URL, stations may be authenticated by either
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "***"
+	}
+	hasUser, hasQuery := u.User != nil, u.RawQuery != "" || u.ForceQuery
+	u.User, u.RawQuery, u.ForceQuery, u.Fragment, u.RawFragment = nil, "", false, "", ""
+
+	redacted := u.String()
+	if hasUser {
+		redacted = strings.Replace(redacted, "//", "//***@", 1)
+	}
+	if hasQuery {
+		redacted += "?***"
+	}
+	return redacted
+}
+
+// Setting is a setting as shown in dumps of the effective configuration
+type Setting struct {
+	Key    string `json:"key"`
+	Env    string `json:"env"`
+	Value  string `json:"value"`  // Masked for secrets, credentials and query masked for URLs
+	Source string `json:"source"` // SourceDefault, SourceFile or SourceEnv
+}
+
+// Settings returns the effective configuration with secrets and URL credentials masked
+func (c *Config) Settings() []Setting {
+	settings := c.settings()
+	dump := make([]Setting, 0, len(settings))
+	for _, s := range settings {
+		value := s.text()
+		if s.secret && value != "" {
+			value = "***"
+		}
+		if s.url {
+			value = RedactURL(value)
+		}
+
+		source := c.sources[s.key]
+		if source == "" {
+			source = SourceDefault
+		}
+		dump = append(dump, Setting{Key: s.key, Env: s.env, Value: value, Source: source})
+	}
+	return dump
+}
+
+// Reload applies the settings of next that can change while the bot runs
+// Returns the resulting configuration, the keys of the settings applied
+// and of the changed ones that need a restart
+func (c *Config) Reload(next *Config) (*Config, []string, []string) {
+	merged := *c
+	merged.File = next.File
+	merged.sources = make(map[string]string, len(c.sources))
+
+	current, incoming, result := c.settings(), next.settings(), merged.settings()
+	var applied, restart []string
+	for i, s := range current {
+		merged.sources[s.key] = c.sources[s.key]
+		if s.text() == incoming[i].text() {
+			continue
+		}
+		if s.restart {
+			restart = append(restart, s.key)
+			continue
+		}
+		result[i].copyFrom(incoming[i])
+		merged.sources[s.key] = next.sources[s.key]
+		applied = append(applied, s.key)
+	}
+	return &merged, applied, restart
+}
+
+// ControlSocketPath returns the path of the control socket
+// Used by `bot ctl` too, which runs without the rest of the configuration
+func ControlSocketPath() string {
+	if cfg, err := load(); err == nil {
+		return cfg.ControlSocket
+	}
+
+	// Fall back to the environment if the configuration is broken
+	if path := os.Getenv("CONTROL_SOCKET"); path != "" {
+		return path
+	}
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+	return filepath.Join(dataDir, "control.sock")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// alternatives lists values like "a, b or c"
+func alternatives(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var listenersDesc = prometheus.NewDesc(
+	"radio_listeners",
+	"Listeners in the voice channel the radio plays in, by guild.",
+	[]string{"guild"}, nil,
+)
+
+// listenersCollector counts listeners on scrape, so the gauge never goes stale
+type listenersCollector struct {
+	metrics *Metrics
+	count   func() map[string]int
+}
+
+// RegisterListeners exposes listener counts computed by count on every scrape
+// count returns listeners by guild ID for guilds where the radio is playing
+func (m *Metrics) RegisterListeners(count func() map[string]int) error {
+	return m.Register(&listenersCollector{metrics: m, count: count})
+}
+
+// Describe implements prometheus.Collector
+func (c *listenersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- listenersDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *listenersCollector) Collect(ch chan<- prometheus.Metric) {
+	// Guilds sharing a label are summed
+	totals := make(map[string]int)
+	for guildID, listeners := range c.count() {
+		totals[c.metrics.GuildLabel(guildID)] += listeners
+	}
+	for label, listeners := range totals {
+		ch <- prometheus.MustNewConstMetric(listenersDesc, prometheus.GaugeValue, float64(listeners), label)
+	}
+}
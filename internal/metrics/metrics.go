@@ -0,0 +1,250 @@
+// Package metrics collects the bot's Prometheus metrics
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Guild label modes
+const (
+	// GuildLabelsAll labels guild metrics with the guild ID
+	GuildLabelsAll = "all"
+	// GuildLabelsNone aggregates guild metrics under a single "all" label
+	GuildLabelsNone = "none"
+)
+
+// Reconnect outcomes
+const (
+	ReconnectSuccess     = "success"
+	ReconnectFailure     = "failure"
+	ReconnectGaveUp      = "gave_up"
+	ReconnectNoListeners = "no_listeners"
+)
+
+// Metrics holds the bot's metrics in its own registry
+type Metrics struct {
+	registry  *prometheus.Registry
+	guilds    map[string]bool // Guilds labelled by ID, nil means all
+	aggregate bool            // Whether every guild is labelled "all"
+	labelsMu  sync.RWMutex    // Guards guilds and aggregate, see SetGuildLabels
+
+	activeStreams    *prometheus.GaugeVec
+	framesSent       *prometheus.CounterVec
+	sendTimeouts     *prometheus.CounterVec
+	encodeErrors     *prometheus.CounterVec
+	reconnects       *prometheus.CounterVec
+	ffmpegRestarts   *prometheus.CounterVec
+	upstreamStalls   *prometheus.CounterVec
+	ffmpegEvents     *prometheus.CounterVec
+	panics           *prometheus.CounterVec
+	connectLatency   *prometheus.HistogramVec
+	gatewayConnected prometheus.Gauge
+}
+
+// New creates the metrics
+// guildLabels is "all", "none" or a comma-separated list of guild IDs to label,
+// other guilds are labelled "other"
+func New(guildLabels string) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		activeStreams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "radio_active_streams",
+			Help: "Streams currently playing, by station host and path.",
+		}, []string{"station"}),
+		framesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "radio_frames_sent_total",
+			Help: "Opus frames sent to Discord.",
+		}, []string{"guild"}),
+		sendTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "radio_send_timeouts_total",
+			Help: "Opus frames that timed out waiting for the voice connection.",
+		}, []string{"guild"}),
+		encodeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "radio_encode_errors_total",
+			Help: "PCM frames that failed to encode to Opus.",
+		}, []string{"guild"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "radio_reconnects_total",
+			Help: "Reconnect attempts by outcome.",
+		}, []string{"guild", "outcome"}),
+		ffmpegRestarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "radio_ffmpeg_restarts_total",
+			Help: "Times ffmpeg ended unexpectedly and the stream had to be restarted.",
+		}, []string{"guild"}),
+		upstreamStalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "radio_upstream_stalls_total",
+			Help: "Times the radio station delivered no audio for longer than the stall threshold.",
+		}, []string{"guild"}),
+		ffmpegEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "radio_ffmpeg_events_total",
+			Help: "Conditions reported by ffmpeg: HTTP errors, reconnects and other errors.",
+		}, []string{"guild", "kind"}),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "radio_panics_total",
+			Help: "Panics recovered by the supervisor, by guild and task. Panics outside guild work are labelled guild=\"shared\".",
+		}, []string{"guild", "task"}),
+		connectLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "radio_voice_connect_seconds",
+			Help:    "Time to join a voice channel and get a ready connection.",
+			Buckets: []float64{0.5, 1, 2, 3, 5, 8, 13, 20, 30},
+		}, []string{"guild", "outcome"}),
+		gatewayConnected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "discord_gateway_connected",
+			Help: "Whether the Discord gateway is connected (1) or not (0).",
+		}),
+	}
+
+	m.SetGuildLabels(guildLabels)
+
+	m.registry.MustRegister(
+		m.activeStreams,
+		m.framesSent,
+		m.sendTimeouts,
+		m.encodeErrors,
+		m.reconnects,
+		m.ffmpegRestarts,
+		m.upstreamStalls,
+		m.ffmpegEvents,
+		m.panics,
+		m.connectLatency,
+		m.gatewayConnected,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Register adds a collector, e.g. one computing values on scrape
+func (m *Metrics) Register(collector prometheus.Collector) error {
+	return m.registry.Register(collector)
+}
+
+// SetGuildLabels changes how guilds are labelled, see New
+// Series already recorded keep their labels
+func (m *Metrics) SetGuildLabels(guildLabels string) {
+	var guilds map[string]bool
+	aggregate := false
+	switch guildLabels {
+	case GuildLabelsAll, "":
+	case GuildLabelsNone:
+		aggregate = true
+	default:
+		guilds = make(map[string]bool)
+		for _, guildID := range strings.Split(guildLabels, ",") {
+			if guildID = strings.TrimSpace(guildID); guildID != "" {
+				guilds[guildID] = true
+			}
+		}
+	}
+
+	m.labelsMu.Lock()
+	defer m.labelsMu.Unlock()
+	m.guilds = guilds
+	m.aggregate = aggregate
+}
+
+// GuildLabel returns the label value for a guild according to the label mode
+func (m *Metrics) GuildLabel(guildID string) string {
+	m.labelsMu.RLock()
+	defer m.labelsMu.RUnlock()
+	switch {
+	case m.aggregate:
+		return "all"
+	case m.guilds == nil || m.guilds[guildID]:
+		return guildID
+	default:
+		return "other"
+	}
+}
+
+// StreamStarted counts a stream playing a station until the returned func is called
+func (m *Metrics) StreamStarted(station string) (done func()) {
+	gauge := m.activeStreams.WithLabelValues(stationLabel(station))
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// stationLabel labels a station URL by host and path, leaving out credentials and the query
+func stationLabel(station string) string {
+	u, err := url.Parse(station)
+	if err != nil || u.Host == "" {
+		return "unknown"
+	}
+	return u.Host + u.Path
+}
+
+// FrameSent counts an Opus frame sent to Discord
+func (m *Metrics) FrameSent(guildID string) {
+	m.framesSent.WithLabelValues(m.GuildLabel(guildID)).Inc()
+}
+
+// SendTimeout counts an Opus frame that timed out
+func (m *Metrics) SendTimeout(guildID string) {
+	m.sendTimeouts.WithLabelValues(m.GuildLabel(guildID)).Inc()
+}
+
+// EncodeError counts a frame that failed to encode
+func (m *Metrics) EncodeError(guildID string) {
+	m.encodeErrors.WithLabelValues(m.GuildLabel(guildID)).Inc()
+}
+
+// Reconnect counts a reconnect attempt with its outcome
+func (m *Metrics) Reconnect(guildID, outcome string) {
+	m.reconnects.WithLabelValues(m.GuildLabel(guildID), outcome).Inc()
+}
+
+// FFmpegRestart counts ffmpeg ending unexpectedly
+func (m *Metrics) FFmpegRestart(guildID string) {
+	m.ffmpegRestarts.WithLabelValues(m.GuildLabel(guildID)).Inc()
+}
+
+// UpstreamStall counts the station stalling
+func (m *Metrics) UpstreamStall(guildID string) {
+	m.upstreamStalls.WithLabelValues(m.GuildLabel(guildID)).Inc()
+}
+
+// FFmpegEvent counts a condition reported by ffmpeg
+func (m *Metrics) FFmpegEvent(guildID, kind string) {
+	m.ffmpegEvents.WithLabelValues(m.GuildLabel(guildID), kind).Inc()
+}
+
+// Panic counts a recovered panic, guildID is empty outside guild work
+func (m *Metrics) Panic(guildID, task string) {
+	label := "shared"
+	if guildID != "" {
+		label = m.GuildLabel(guildID)
+	}
+	m.panics.WithLabelValues(label, task).Inc()
+}
+
+// VoiceConnected records how long joining a voice channel took
+func (m *Metrics) VoiceConnected(guildID string, d time.Duration, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	m.connectLatency.WithLabelValues(m.GuildLabel(guildID), outcome).Observe(d.Seconds())
+}
+
+// SetGatewayConnected records the gateway status
+func (m *Metrics) SetGatewayConnected(connected bool) {
+	if connected {
+		m.gatewayConnected.Set(1)
+	} else {
+		m.gatewayConnected.Set(0)
+	}
+}
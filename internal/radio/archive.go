@@ -0,0 +1,124 @@
+package radio
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ArchivedGuild is the configuration of a guild the bot left, kept in case it comes back
+// It stays in the schema version it was archived with until it's taken, see Take
+type ArchivedGuild struct {
+	ArchivedAt time.Time `json:"archived_at"`
+	Version    int       `json:"version,omitempty"` // Schema version of Config, 1 if missing
+	Config     rawGuild  `json:"config"`
+}
+
+// Archive keeps configurations of guilds the bot left in a JSON file
+// An empty path keeps them in memory only
+type Archive struct {
+	path   string
+	guilds map[string]ArchivedGuild
+	mu     sync.Mutex
+}
+
+// NewArchive opens the archive, loading the archived guilds
+func NewArchive(path string) (*Archive, error) {
+	a := &Archive{
+		path:   path,
+		guilds: make(map[string]ArchivedGuild),
+	}
+	if path == "" {
+		return a, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &a.guilds); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return a, nil
+}
+
+// Put archives the configuration of a guild
+func (a *Archive) Put(guildID string, config GuildConfig) error {
+	fields, err := configFields(config)
+	if err != nil {
+		return fmt.Errorf("failed to encode guild %s: %w", guildID, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.guilds[guildID] = ArchivedGuild{ArchivedAt: time.Now(), Version: SchemaVersion, Config: fields}
+	return a.writeUnsafe()
+}
+
+// Take removes a guild from the archive and returns its configuration, migrated to the current schema version
+// A guild that can't be migrated, e.g. archived by a newer build, stays archived
+func (a *Archive) Take(guildID string) (GuildConfig, bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	archived, exists := a.guilds[guildID]
+	if !exists {
+		return GuildConfig{}, false, nil
+	}
+
+	version := archived.Version
+	if version == 0 {
+		version = 1
+	}
+	// Migrations change the fields in place, the archived copy stays as it was if one fails
+	guild := make(rawGuild, len(archived.Config))
+	for field, value := range archived.Config {
+		guild[field] = value
+	}
+	guilds := map[string]rawGuild{guildID: guild}
+	if err := migrateGuilds(guilds, version); err != nil {
+		return GuildConfig{}, false, fmt.Errorf("archived guild %s: %w", guildID, err)
+	}
+	config, err := decodeGuild(guilds[guildID])
+	if err != nil {
+		return GuildConfig{}, false, fmt.Errorf("failed to decode archived guild %s: %w", guildID, err)
+	}
+
+	delete(a.guilds, guildID)
+	return config, true, a.writeUnsafe()
+}
+
+// Purge drops guilds archived longer than ttl ago
+// Returns how many were dropped
+func (a *Archive) Purge(ttl time.Duration) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	purged := 0
+	for guildID, archived := range a.guilds {
+		if time.Since(archived.ArchivedAt) > ttl {
+			delete(a.guilds, guildID)
+			purged++
+		}
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	return purged, a.writeUnsafe()
+}
+
+// writeUnsafe writes the archive without locking (internal use)
+func (a *Archive) writeUnsafe() error {
+	if a.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(a.guilds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode archive: %w", err)
+	}
+	return writeFileAtomic(a.path, data)
+}
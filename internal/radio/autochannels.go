@@ -0,0 +1,72 @@
+package radio
+
+// Rules for picking an auto-channel when several are occupied
+const (
+	// RuleMostListeners picks the channel with the most listeners, ties go to the higher priority
+	RuleMostListeners = "most_listeners"
+	// RulePriority picks the occupied channel with the highest priority
+	RulePriority = "priority"
+	// RuleFirstOccupied picks the first occupied channel in list order
+	RuleFirstOccupied = "first_occupied"
+)
+
+// AutoChannel is a voice channel the radio joins when people are in it
+type AutoChannel struct {
+	ChannelID      string `json:"channel_id"`
+	Priority       int    `json:"priority,omitempty"`
+	DisabledReason string `json:"disabled_reason,omitempty"` // Why the bot skips this channel, empty if usable
+}
+
+// IsValidAutoChannelRule reports whether rule is a known selection rule
+func IsValidAutoChannelRule(rule string) bool {
+	switch rule {
+	case RuleMostListeners, RulePriority, RuleFirstOccupied:
+		return true
+	}
+	return false
+}
+
+// PickAutoChannel picks the channel to play in according to rule
+// listeners returns the listener count of a channel; channels without listeners
+// and disabled channels are never picked. Returns nil if no channel qualifies.
+func PickAutoChannel(channels []AutoChannel, rule string, listeners func(channelID string) int) (*AutoChannel, int) {
+	var best *AutoChannel
+	bestCount := 0
+
+	for i := range channels {
+		channel := &channels[i]
+		if channel.DisabledReason != "" {
+			continue
+		}
+
+		count := listeners(channel.ChannelID)
+		if count == 0 {
+			continue
+		}
+
+		if best == nil {
+			best, bestCount = channel, count
+			if rule == RuleFirstOccupied {
+				break
+			}
+			continue
+		}
+
+		switch rule {
+		case RulePriority:
+			if channel.Priority > best.Priority {
+				best, bestCount = channel, count
+			}
+		default:
+			if count > bestCount || (count == bestCount && channel.Priority > best.Priority) {
+				best, bestCount = channel, count
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, 0
+	}
+	result := *best
+	return &result, bestCount
+}
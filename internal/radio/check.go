@@ -0,0 +1,108 @@
+package radio
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// StorageCheck is the result of checking the saved configuration
+type StorageCheck struct {
+	Path    string // File of the backend, empty for memory storage
+	Version int    // Schema version of the file
+	Guilds  int
+	// Settings that can't be applied, by guild ID
+	Invalid map[string]error
+}
+
+// CheckStorage reads the saved guild configuration of a backend like the bot would,
+// without migrating or otherwise changing any file
+func CheckStorage(backend, dir string) (*StorageCheck, error) {
+	check := &StorageCheck{Version: SchemaVersion, Invalid: make(map[string]error)}
+
+	var guilds map[string]rawGuild
+	var err error
+	switch backend {
+	case StorageJSON, "":
+		check.Path = filepath.Join(dir, "radio_config.json")
+		guilds, check.Version, err = readCheckedFile(check.Path, parseConfigFile)
+	case StorageJournal:
+		check.Path = filepath.Join(dir, "radio_config.journal")
+		guilds, check.Version, err = readCheckedFile(check.Path, func(data []byte) (map[string]rawGuild, int, error) {
+			guilds, version, _, err := parseJournal(data)
+			return guilds, version, err
+		})
+	case StorageMemory:
+		return check, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateGuilds(guilds, check.Version); err != nil {
+		return nil, fmt.Errorf("%s: %w", check.Path, err)
+	}
+	configs, err := decodeGuilds(guilds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", check.Path, err)
+	}
+
+	check.Guilds = len(configs)
+	for guildID, config := range configs {
+		if err := config.Validate(); err != nil {
+			check.Invalid[guildID] = err
+		}
+	}
+	return check, nil
+}
+
+// InvalidGuilds returns the IDs of the guilds with invalid settings, sorted
+func (c *StorageCheck) InvalidGuilds() []string {
+	guildIDs := make([]string, 0, len(c.Invalid))
+	for guildID := range c.Invalid {
+		guildIDs = append(guildIDs, guildID)
+	}
+	sort.Strings(guildIDs)
+	return guildIDs
+}
+
+// readCheckedFile reads and parses a storage file, a missing file holds no guilds
+func readCheckedFile(path string, parse func(data []byte) (map[string]rawGuild, int, error)) (map[string]rawGuild, int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]rawGuild{}, SchemaVersion, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	guilds, version, err := parse(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return guilds, version, nil
+}
+
+// CheckArchive reads the archive of guilds the bot left
+// Returns its path and the number of archived guilds
+func CheckArchive(backend, dir string) (string, int, error) {
+	if backend == StorageMemory {
+		return "", 0, nil
+	}
+	path := filepath.Join(dir, "guild_archive.json")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return path, 0, nil
+	}
+	if err != nil {
+		return path, 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var guilds map[string]ArchivedGuild
+	if err := json.Unmarshal(data, &guilds); err != nil {
+		return path, 0, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return path, len(guilds), nil
+}
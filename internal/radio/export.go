@@ -0,0 +1,229 @@
+package radio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// GuildExport is a guild's settings as exported to a file, to copy them to another guild
+type GuildExport struct {
+	Version  int               `json:"version"`
+	GuildID  string            `json:"guild_id"`
+	Channels map[string]string `json:"channels,omitempty"` // Names of the referenced channels by ID
+	Roles    map[string]string `json:"roles,omitempty"`    // Names of the referenced roles by ID
+	Config   GuildConfig       `json:"config"`
+}
+
+// ConfigChange is a setting that differs between two guild configurations
+type ConfigChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// ExportGuild returns the settings of a guild without its runtime state
+func (m *Manager) ExportGuild(guildID string) GuildConfig {
+	config := guildConfigFromState(m.GetOrCreate(guildID))
+	config.Session = nil
+	// Re-checked against the target guild's permissions on import
+	for i := range config.AutoChannels {
+		config.AutoChannels[i].DisabledReason = ""
+	}
+	return config
+}
+
+// ImportGuild replaces the settings of a guild and saves them
+// A playing session is kept
+func (m *Manager) ImportGuild(guildID string, config GuildConfig) error {
+	applyGuildConfig(m.GetOrCreate(guildID), config)
+	return m.SaveState(guildID)
+}
+
+// ParseGuildExport reads an export file, migrating settings exported by an older version
+func ParseGuildExport(data []byte) (*GuildExport, error) {
+	var file struct {
+		Version  int               `json:"version"`
+		GuildID  string            `json:"guild_id"`
+		Channels map[string]string `json:"channels"`
+		Roles    map[string]string `json:"roles"`
+		Config   rawGuild          `json:"config"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid export file: %w", err)
+	}
+	if file.Version == 0 || file.Config == nil {
+		return nil, fmt.Errorf("invalid export file: missing version or config")
+	}
+
+	if err := migrateGuilds(map[string]rawGuild{file.GuildID: file.Config}, file.Version); err != nil {
+		return nil, err
+	}
+
+	config, err := decodeGuild(file.Config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid export file: %w", err)
+	}
+	config.Session = nil
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &GuildExport{
+		Version:  SchemaVersion,
+		GuildID:  file.GuildID,
+		Channels: file.Channels,
+		Roles:    file.Roles,
+		Config:   config,
+	}, nil
+}
+
+// Validate checks the settings that don't depend on a guild
+func (c GuildConfig) Validate() error {
+	if c.AutoChannelRule != "" && !IsValidAutoChannelRule(c.AutoChannelRule) {
+		return fmt.Errorf("unknown auto-channel rule %q", c.AutoChannelRule)
+	}
+	if _, err := c.AutoConnectRules.Location(); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", c.AutoConnectRules.Timezone, err)
+	}
+	for _, day := range c.AutoConnectRules.Days {
+		if day < 0 || day > 6 {
+			return fmt.Errorf("invalid weekday %d", day)
+		}
+	}
+	for _, clock := range []string{c.AutoConnectRules.From, c.AutoConnectRules.To} {
+		if clock == "" {
+			continue
+		}
+		if _, err := ParseClock(clock); err != nil {
+			return err
+		}
+	}
+	if c.IdleGraceSeconds != nil && *c.IdleGraceSeconds < 0 {
+		return fmt.Errorf("negative idle grace period")
+	}
+	if c.FollowUserID != "" && !isSnowflake(c.FollowUserID) {
+		return fmt.Errorf("invalid followed user ID %q", c.FollowUserID)
+	}
+	for _, userID := range c.ListenerPolicy.IgnoredUsers {
+		if !isSnowflake(userID) {
+			return fmt.Errorf("invalid ignored user ID %q", userID)
+		}
+	}
+	if c.Station != "" {
+		if err := ValidateStation(c.Station); err != nil {
+			return err
+		}
+	}
+	if c.Volume != nil {
+		if err := ValidateVolume(*c.Volume); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSnowflake reports whether id looks like a Discord ID
+func isSnowflake(id string) bool {
+	if len(id) < 17 || len(id) > 20 {
+		return false
+	}
+	_, err := strconv.ParseUint(id, 10, 64)
+	return err == nil
+}
+
+// ChannelIDs returns the channels the settings refer to
+func (c GuildConfig) ChannelIDs() []string {
+	ids := make([]string, 0, len(c.AutoChannels))
+	for _, channel := range c.AutoChannels {
+		ids = append(ids, channel.ChannelID)
+	}
+	return ids
+}
+
+// RoleIDs returns the roles the settings refer to
+func (c GuildConfig) RoleIDs() []string {
+	ids := append([]string(nil), c.AutoConnectRules.TriggerRoles...)
+	for _, role := range c.ListenerPolicy.IgnoredRoles {
+		if !contains(ids, role) {
+			ids = append(ids, role)
+		}
+	}
+	return ids
+}
+
+// WithIDs returns a copy of the settings with channel and role IDs replaced,
+// IDs missing from the maps are kept
+func (c GuildConfig) WithIDs(channels, roles map[string]string) GuildConfig {
+	replace := func(ids map[string]string, id string) string {
+		if mapped, exists := ids[id]; exists {
+			return mapped
+		}
+		return id
+	}
+
+	result := c
+	result.AutoChannels = make([]AutoChannel, 0, len(c.AutoChannels))
+	for _, channel := range c.AutoChannels {
+		channel.ChannelID = replace(channels, channel.ChannelID)
+		result.AutoChannels = append(result.AutoChannels, channel)
+	}
+
+	result.AutoConnectRules = c.AutoConnectRules.Clone()
+	for i, role := range result.AutoConnectRules.TriggerRoles {
+		result.AutoConnectRules.TriggerRoles[i] = replace(roles, role)
+	}
+
+	result.ListenerPolicy = c.ListenerPolicy.Clone()
+	for i, role := range result.ListenerPolicy.IgnoredRoles {
+		result.ListenerPolicy.IgnoredRoles[i] = replace(roles, role)
+	}
+	return result
+}
+
+// DiffGuildConfigs lists the settings that differ between two configurations
+func DiffGuildConfigs(before, after GuildConfig) ([]ConfigChange, error) {
+	oldFields, err := configFields(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := configFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0, len(oldFields)+len(newFields))
+	for field := range oldFields {
+		fields = append(fields, field)
+	}
+	for field := range newFields {
+		if _, exists := oldFields[field]; !exists {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var changes []ConfigChange
+	for _, field := range fields {
+		oldValue, newValue := oldFields[field], newFields[field]
+		if bytes.Equal(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, ConfigChange{Field: field, Old: string(oldValue), New: string(newValue)})
+	}
+	return changes, nil
+}
+
+// configFields returns the JSON encoded fields of a configuration
+func configFields(config GuildConfig) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
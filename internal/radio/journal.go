@@ -0,0 +1,233 @@
+package radio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// journalCompactRatio is how many entries per guild the journal may hold before it is compacted
+const journalCompactRatio = 4
+
+// journalEntry is one line of the journal
+// The first line of a compacted journal only carries the schema version
+type journalEntry struct {
+	Version int             `json:"version,omitempty"`
+	GuildID string          `json:"guild_id,omitempty"`
+	Config  json.RawMessage `json:"config,omitempty"` // Empty means the guild was deleted
+}
+
+// JournalStorage appends every change to a journal file instead of rewriting everything
+// The journal is replayed on load and compacted once it grows much larger than the data
+type JournalStorage struct {
+	path    string
+	file    *os.File
+	configs map[string]GuildConfig
+	entries int
+	mu      sync.Mutex
+}
+
+// NewJournalStorage creates a journaled storage, creating the file's directory if needed
+func NewJournalStorage(path string) (*JournalStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &JournalStorage{
+		path:    path,
+		configs: make(map[string]GuildConfig),
+	}, nil
+}
+
+// Load replays the journal, migrating it to the current schema version
+// Journals from a newer version are refused rather than overwritten
+func (j *JournalStorage) Load() (map[string]GuildConfig, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	data, err := os.ReadFile(j.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", j.path, err)
+	}
+
+	guilds, version, entries, err := parseJournal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", j.path, err)
+	}
+
+	if err := migrateGuilds(guilds, version); err != nil {
+		return nil, fmt.Errorf("%s: %w", j.path, err)
+	}
+
+	configs, err := decodeGuilds(guilds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", j.path, err)
+	}
+
+	if version < SchemaVersion && len(data) > 0 {
+		if err := backupFile(j.path, data, version); err != nil {
+			return nil, err
+		}
+	}
+
+	j.configs = configs
+	j.entries = entries
+
+	// Compacting also drops a partial last line
+	if err := j.compactUnsafe(); err != nil {
+		return nil, err
+	}
+
+	return copyConfigs(configs), nil
+}
+
+// Save appends a guild configuration to the journal
+func (j *JournalStorage) Save(guildID string, config GuildConfig) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.configs[guildID] = config
+	return j.appendUnsafe(guildID, &config)
+}
+
+// Delete appends a guild deletion to the journal
+func (j *JournalStorage) Delete(guildID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, exists := j.configs[guildID]; !exists {
+		return nil
+	}
+	delete(j.configs, guildID)
+	return j.appendUnsafe(guildID, nil)
+}
+
+// Close closes the journal file
+func (j *JournalStorage) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// appendUnsafe writes one entry without locking (internal use)
+// A nil config records a deletion
+func (j *JournalStorage) appendUnsafe(guildID string, config *GuildConfig) error {
+	// The compacted journal already contains the entry
+	if j.entries > journalCompactRatio*(len(j.configs)+1) {
+		return j.compactUnsafe()
+	}
+
+	if j.file == nil {
+		file, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", j.path, err)
+		}
+		j.file = file
+	}
+
+	line, err := encodeJournalEntry(guildID, config)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append to %s: %w", j.path, err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", j.path, err)
+	}
+
+	j.entries++
+	return nil
+}
+
+// compactUnsafe rewrites the journal with one entry per guild without locking (internal use)
+func (j *JournalStorage) compactUnsafe() error {
+	var buf bytes.Buffer
+
+	header, err := json.Marshal(journalEntry{Version: SchemaVersion})
+	if err != nil {
+		return fmt.Errorf("failed to encode journal header: %w", err)
+	}
+	buf.Write(header)
+	buf.WriteByte('\n')
+
+	for guildID, config := range j.configs {
+		config := config
+		line, err := encodeJournalEntry(guildID, &config)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	// The open file points to the replaced journal
+	if j.file != nil {
+		_ = j.file.Close()
+		j.file = nil
+	}
+
+	if err := writeFileAtomic(j.path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	j.entries = len(j.configs)
+	return nil
+}
+
+// encodeJournalEntry encodes one journal line, a nil config records a deletion
+func encodeJournalEntry(guildID string, config *GuildConfig) ([]byte, error) {
+	entry := journalEntry{GuildID: guildID}
+	if config != nil {
+		data, err := json.Marshal(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode journal entry: %w", err)
+		}
+		entry.Config = data
+	}
+	return json.Marshal(entry)
+}
+
+// parseJournal replays a journal into raw guild configurations
+// Returns them with the schema version and the number of entries
+func parseJournal(data []byte) (map[string]rawGuild, int, int, error) {
+	guilds := make(map[string]rawGuild)
+	version := 1
+	entries := 0
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			// A crash in the middle of an append leaves a partial last line
+			if i == len(lines)-1 {
+				break
+			}
+			return nil, 0, 0, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		switch {
+		case entry.GuildID == "":
+			version = entry.Version
+		case len(entry.Config) == 0:
+			delete(guilds, entry.GuildID)
+		default:
+			var guild rawGuild
+			if err := json.Unmarshal(entry.Config, &guild); err != nil {
+				return nil, 0, 0, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			guilds[entry.GuildID] = guild
+		}
+		entries++
+	}
+	return guilds, version, entries, nil
+}
@@ -0,0 +1,61 @@
+package radio
+
+// ListenerPolicy decides which members of a voice channel count as listeners
+type ListenerPolicy struct {
+	CountDeafened bool     `json:"count_deafened,omitempty"` // Whether deafened members count as listeners
+	IgnoredUsers  []string `json:"ignored_users,omitempty"`  // Users that never count as listeners
+	IgnoredRoles  []string `json:"ignored_roles,omitempty"`  // Members with any of these roles never count
+}
+
+// IsUserIgnored returns whether the user is excluded by the policy
+func (p ListenerPolicy) IsUserIgnored(userID string) bool {
+	return contains(p.IgnoredUsers, userID)
+}
+
+// HasIgnoredRole returns whether any of the roles is excluded by the policy
+func (p ListenerPolicy) HasIgnoredRole(roles []string) bool {
+	for _, role := range roles {
+		if contains(p.IgnoredRoles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a copy that doesn't share slices with p
+func (p ListenerPolicy) Clone() ListenerPolicy {
+	return ListenerPolicy{
+		CountDeafened: p.CountDeafened,
+		IgnoredUsers:  append([]string(nil), p.IgnoredUsers...),
+		IgnoredRoles:  append([]string(nil), p.IgnoredRoles...),
+	}
+}
+
+// contains reports whether value is in list
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// addUnique appends value to list unless it is already there
+func addUnique(list []string, value string) []string {
+	if contains(list, value) {
+		return list
+	}
+	return append(list, value)
+}
+
+// without returns list with every occurrence of value removed
+func without(list []string, value string) []string {
+	result := list[:0:0]
+	for _, item := range list {
+		if item != value {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -1,100 +1,145 @@
 package radio
 
 import (
-	"encoding/json"
-	"os"
+	"fmt"
 	"sync"
+	"time"
 )
 
 // Manager manages radio states for multiple guilds
 type Manager struct {
-	states     map[string]*State
-	configFile string
-	mu         sync.RWMutex
+	states  map[string]*State
+	storage Storage
+	mu      sync.RWMutex
 }
 
 // GuildConfig represents saved configuration for a guild
 type GuildConfig struct {
-	AutoChannelID      string `json:"auto_channel_id"`
-	AutoConnectEnabled bool   `json:"auto_connect_enabled"`
-}
-
-// NewManager creates a new radio state manager
-func NewManager() *Manager {
-	// Use data directory for persistence
-	configDir := "data"
-	configFile := configDir + "/radio_config.json"
-	
-	// Create data directory if it doesn't exist
-	_ = os.MkdirAll(configDir, 0755)
-	
+	AutoChannels       []AutoChannel    `json:"auto_channels,omitempty"`
+	AutoChannelRule    string           `json:"auto_channel_rule,omitempty"`
+	AutoConnectEnabled bool             `json:"auto_connect_enabled"`
+	AutoConnectRules   AutoConnectRules `json:"auto_connect_rules"`
+	IdleGraceSeconds   *int             `json:"idle_grace_seconds,omitempty"`
+	IdleMute           bool             `json:"idle_mute,omitempty"`
+	ListenerPolicy     ListenerPolicy   `json:"listener_policy"`
+	FollowUserID       string           `json:"follow_user_id,omitempty"`
+	Station            string           `json:"station,omitempty"` // Empty plays RADIO_URL
+	Volume             *int             `json:"volume,omitempty"`  // Percent, nil for DefaultVolume
+	Session            *Session         `json:"session,omitempty"`
+}
+
+// NewManager creates a new radio state manager and loads the saved configuration
+func NewManager(storage Storage) (*Manager, error) {
 	m := &Manager{
-		states:     make(map[string]*State),
-		configFile: configFile,
+		states:  make(map[string]*State),
+		storage: storage,
+	}
+	if err := m.LoadConfig(); err != nil {
+		return nil, err
 	}
-	m.LoadConfig()
-	return m
+	return m, nil
 }
 
-// LoadConfig loads saved configuration from file
-func (m *Manager) LoadConfig() {
+// LoadConfig loads saved configuration from storage
+func (m *Manager) LoadConfig() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	data, err := os.ReadFile(m.configFile)
+	configs, err := m.storage.Load()
 	if err != nil {
-		// File doesn't exist yet, that's okay
-		return
-	}
-
-	var configs map[string]GuildConfig
-	if err := json.Unmarshal(data, &configs); err != nil {
-		return
+		return fmt.Errorf("failed to load guild configuration: %w", err)
 	}
 
 	// Load saved configs into states
 	for guildID, config := range configs {
 		state := m.getOrCreateUnsafe(guildID)
-		state.SetAutoChannelID(config.AutoChannelID)
-		state.SetAutoConnectEnabled(config.AutoConnectEnabled)
+		applyGuildConfig(state, config)
+		if config.Session != nil {
+			state.StartSession(*config.Session)
+		}
 	}
+	return nil
 }
 
-// SaveConfig saves current configuration to file
-func (m *Manager) SaveConfig() {
+// ReloadConfig reads the saved configuration again, e.g. after it was edited by hand
+// Settings of the saved guilds are replaced, running sessions are kept
+// Returns the number of guilds loaded
+func (m *Manager) ReloadConfig() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	configs, err := m.storage.Load()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load guild configuration: %w", err)
+	}
+
+	for guildID, config := range configs {
+		applyGuildConfig(m.getOrCreateUnsafe(guildID), config)
+	}
+	return len(configs), nil
+}
+
+// SaveConfig saves the configuration of every guild to storage
+func (m *Manager) SaveConfig() error {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	configs := make(map[string]GuildConfig)
 	for guildID, state := range m.states {
-		configs[guildID] = GuildConfig{
-			AutoChannelID:      state.GetAutoChannelID(),
-			AutoConnectEnabled: state.IsAutoConnectEnabled(),
+		if err := m.storage.Save(guildID, guildConfigFromState(state)); err != nil {
+			return fmt.Errorf("failed to save configuration of guild %s: %w", guildID, err)
 		}
 	}
+	return nil
+}
 
-	data, err := json.MarshalIndent(configs, "", "  ")
-	if err != nil {
-		// Log error but don't fail - use standard log for now
-		return
+// applyGuildConfig applies saved settings to a guild's state
+func applyGuildConfig(state *State, config GuildConfig) {
+	state.SetAutoChannels(config.AutoChannels)
+	rule := RuleMostListeners
+	if IsValidAutoChannelRule(config.AutoChannelRule) {
+		rule = config.AutoChannelRule
 	}
-
-	// Ensure directory exists before writing
-	configDir := "data"
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return
+	state.SetAutoChannelRule(rule)
+	state.SetAutoConnectEnabled(config.AutoConnectEnabled)
+	state.SetAutoConnectRules(config.AutoConnectRules)
+	grace := time.Duration(-1)
+	if config.IdleGraceSeconds != nil {
+		grace = time.Duration(*config.IdleGraceSeconds) * time.Second
 	}
+	state.SetIdleGrace(grace)
+	state.SetIdleMute(config.IdleMute)
+	state.SetListenerPolicy(config.ListenerPolicy)
+	state.SetFollowUserID(config.FollowUserID)
+	state.SetStation(config.Station)
+	volume := -1
+	if config.Volume != nil {
+		volume = *config.Volume
+	}
+	state.SetVolume(volume)
+}
 
-	// Write file atomically using temp file
-	tmpFile := m.configFile + ".tmp"
-	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
-		return
+// guildConfigFromState builds the saved configuration of a guild
+func guildConfigFromState(state *State) GuildConfig {
+	config := GuildConfig{
+		AutoChannels:       state.GetAutoChannels(),
+		AutoChannelRule:    state.GetAutoChannelRule(),
+		AutoConnectEnabled: state.IsAutoConnectEnabled(),
+		AutoConnectRules:   state.GetAutoConnectRules(),
+		IdleMute:           state.IsIdleMute(),
+		ListenerPolicy:     state.GetListenerPolicy(),
+		FollowUserID:       state.GetFollowUserID(),
+		Station:            state.GetStation(),
+		Session:            state.GetSession(),
+	}
+	if grace := state.GetIdleGrace(); grace >= 0 {
+		seconds := int(grace / time.Second)
+		config.IdleGraceSeconds = &seconds
 	}
-	
-	// Atomic rename
-	if err := os.Rename(tmpFile, m.configFile); err != nil {
-		_ = os.Remove(tmpFile) // Clean up temp file on error
+	if state.HasVolume() {
+		volume := state.GetVolume()
+		config.Volume = &volume
 	}
+	return config
 }
 
 // getOrCreateUnsafe gets or creates a state without locking (internal use)
@@ -114,9 +159,16 @@ func (m *Manager) GetOrCreate(guildID string) *State {
 	return m.getOrCreateUnsafe(guildID)
 }
 
-// SaveState saves state configuration to file
-func (m *Manager) SaveState(guildID string) {
-	m.SaveConfig()
+// SaveState saves the configuration of a guild to storage
+func (m *Manager) SaveState(guildID string) error {
+	state, exists := m.Get(guildID)
+	if !exists {
+		return nil
+	}
+	if err := m.storage.Save(guildID, guildConfigFromState(state)); err != nil {
+		return fmt.Errorf("failed to save configuration of guild %s: %w", guildID, err)
+	}
+	return nil
 }
 
 // Get gets a state for a guild (read-only)
@@ -139,10 +191,42 @@ func (m *Manager) GetAllGuildIDs() []string {
 	return guilds
 }
 
-// Remove removes a state for a guild
-func (m *Manager) Remove(guildID string) {
+// Remove forgets a guild and deletes its saved configuration
+func (m *Manager) Remove(guildID string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	delete(m.states, guildID)
+	m.mu.Unlock()
+
+	if err := m.storage.Delete(guildID); err != nil {
+		return fmt.Errorf("failed to delete configuration of guild %s: %w", guildID, err)
+	}
+	return nil
 }
 
+// ArchiveGuild moves a guild's configuration to the archive and forgets the guild
+func (m *Manager) ArchiveGuild(guildID string, archive *Archive) error {
+	config := m.ExportGuild(guildID)
+	if err := archive.Put(guildID, config); err != nil {
+		return fmt.Errorf("failed to archive guild %s: %w", guildID, err)
+	}
+	return m.Remove(guildID)
+}
+
+// UnarchiveGuild restores a guild's configuration from the archive
+// Returns false if the guild wasn't archived
+func (m *Manager) UnarchiveGuild(guildID string, archive *Archive) (bool, error) {
+	config, exists, err := archive.Take(guildID)
+	if !exists {
+		return false, err
+	}
+	if importErr := m.ImportGuild(guildID, config); importErr != nil {
+		return true, importErr
+	}
+	return true, err
+}
+
+
+// Close closes the storage
+func (m *Manager) Close() error {
+	return m.storage.Close()
+}
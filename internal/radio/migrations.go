@@ -0,0 +1,101 @@
+package radio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// SchemaVersion is the version of the guild configuration format written by this build
+// Files without a version are version 1
+const SchemaVersion = 2
+
+// ErrNewerSchema is returned for configuration written by a newer build
+var ErrNewerSchema = errors.New("configuration was written by a newer version of the bot")
+
+// rawGuild is a guild configuration as raw JSON fields, the form migrations work on
+type rawGuild map[string]json.RawMessage
+
+// migrations upgrade a guild configuration from the version they are keyed by to the next one
+var migrations = map[int]func(guild rawGuild) error{
+	1: migrateV1ToV2,
+}
+
+// migrateV1ToV2 turns the single auto_channel_id into the auto_channels list
+func migrateV1ToV2(guild rawGuild) error {
+	raw, exists := guild["auto_channel_id"]
+	if !exists {
+		return nil
+	}
+	delete(guild, "auto_channel_id")
+
+	var channelID string
+	if err := json.Unmarshal(raw, &channelID); err != nil {
+		return fmt.Errorf("invalid auto_channel_id: %w", err)
+	}
+	if _, exists := guild["auto_channels"]; exists || channelID == "" {
+		return nil
+	}
+
+	channels, err := json.Marshal([]AutoChannel{{ChannelID: channelID}})
+	if err != nil {
+		return err
+	}
+	guild["auto_channels"] = channels
+	return nil
+}
+
+// migrateGuilds upgrades raw guild configurations from a schema version to SchemaVersion
+func migrateGuilds(guilds map[string]rawGuild, from int) error {
+	if from > SchemaVersion {
+		return fmt.Errorf("%w: version %d, this build supports up to %d", ErrNewerSchema, from, SchemaVersion)
+	}
+
+	for version := from; version < SchemaVersion; version++ {
+		migrate, exists := migrations[version]
+		if !exists {
+			return fmt.Errorf("no migration from version %d", version)
+		}
+		for guildID, guild := range guilds {
+			if err := migrate(guild); err != nil {
+				return fmt.Errorf("failed to migrate guild %s to version %d: %w", guildID, version+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeGuild converts a raw guild configuration into a GuildConfig
+func decodeGuild(guild rawGuild) (GuildConfig, error) {
+	var config GuildConfig
+	data, err := json.Marshal(guild)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(data, &config)
+	return config, err
+}
+
+// decodeGuilds converts raw guild configurations into GuildConfigs
+func decodeGuilds(guilds map[string]rawGuild) (map[string]GuildConfig, error) {
+	configs := make(map[string]GuildConfig, len(guilds))
+	for guildID, guild := range guilds {
+		config, err := decodeGuild(guild)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode guild %s: %w", guildID, err)
+		}
+		configs[guildID] = config
+	}
+	return configs, nil
+}
+
+// backupFile keeps a copy of a file before it is migrated
+func backupFile(path string, data []byte, version int) error {
+	backup := fmt.Sprintf("%s.v%d.%s.bak", path, version, time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(backup, data, 0644); err != nil {
+		return fmt.Errorf("failed to back up %s before migrating: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package radio
+
+import (
+	"fmt"
+	"time"
+)
+
+// AutoConnectRules restrict when auto-connect triggers
+type AutoConnectRules struct {
+	MinListeners int      `json:"min_listeners,omitempty"` // Listeners needed before joining, 1 if unset
+	Timezone     string   `json:"timezone,omitempty"`      // IANA time zone for Days and hours, UTC if empty
+	Days         []int    `json:"days,omitempty"`          // Allowed weekdays (0 is Sunday), every day if empty
+	From         string   `json:"from,omitempty"`          // Start of the allowed hours as HH:MM, all day if empty
+	To           string   `json:"to,omitempty"`            // End of the allowed hours as HH:MM, may wrap past midnight
+	TriggerRoles []string `json:"trigger_roles,omitempty"` // Only members with one of these roles trigger, anyone if empty
+}
+
+// Clone returns a copy that doesn't share slices with r
+func (r AutoConnectRules) Clone() AutoConnectRules {
+	clone := r
+	clone.Days = append([]int(nil), r.Days...)
+	clone.TriggerRoles = append([]string(nil), r.TriggerRoles...)
+	return clone
+}
+
+// RequiredListeners returns how many listeners are needed before joining
+func (r AutoConnectRules) RequiredListeners() int {
+	if r.MinListeners < 1 {
+		return 1
+	}
+	return r.MinListeners
+}
+
+// Location returns the rules' time zone
+func (r AutoConnectRules) Location() (*time.Location, error) {
+	if r.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(r.Timezone)
+}
+
+// AllowsTime reports whether auto-connect may trigger at the given moment
+func (r AutoConnectRules) AllowsTime(now time.Time) (bool, error) {
+	loc, err := r.Location()
+	if err != nil {
+		return false, fmt.Errorf("invalid timezone %q: %w", r.Timezone, err)
+	}
+	local := now.In(loc)
+
+	if len(r.Days) > 0 {
+		allowed := false
+		for _, day := range r.Days {
+			if time.Weekday(day) == local.Weekday() {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false, nil
+		}
+	}
+
+	if r.From == "" || r.To == "" {
+		return true, nil
+	}
+
+	from, err := ParseClock(r.From)
+	if err != nil {
+		return false, err
+	}
+	to, err := ParseClock(r.To)
+	if err != nil {
+		return false, err
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	if from <= to {
+		return minute >= from && minute < to, nil
+	}
+	// Window wraps past midnight, e.g. 22:00-02:00
+	return minute >= from || minute < to, nil
+}
+
+// AllowsRoles reports whether a member with these roles may trigger auto-connect
+func (r AutoConnectRules) AllowsRoles(roles []string) bool {
+	if len(r.TriggerRoles) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		if contains(r.TriggerRoles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseClock parses HH:MM into minutes since midnight
+func ParseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
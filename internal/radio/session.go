@@ -0,0 +1,52 @@
+package radio
+
+import "time"
+
+// Session is a radio session that was playing in a guild
+// Persisted so it can be resumed after a restart
+// The station and volume aren't part of it: they are guild settings, persisted on their own
+type Session struct {
+	ChannelID string    `json:"channel_id"`
+	StartedBy string    `json:"started_by,omitempty"` // User who started the radio, empty if started automatically
+	StartedAt time.Time `json:"started_at"`
+}
+
+// StartSession records a new playing session, replacing any previous one
+func (s *State) StartSession(session Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session = &session
+}
+
+// MoveSession updates the channel of the current session
+// Returns false if there is no session
+func (s *State) MoveSession(channelID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.session == nil {
+		return false
+	}
+	s.session.ChannelID = channelID
+	return true
+}
+
+// EndSession forgets the current session
+// Returns true if there was one
+func (s *State) EndSession() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ended := s.session != nil
+	s.session = nil
+	return ended
+}
+
+// GetSession returns a copy of the current session, nil if none
+func (s *State) GetSession() *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.session == nil {
+		return nil
+	}
+	session := *s.session
+	return &session
+}
@@ -1,16 +1,37 @@
 package radio
 
 import (
+	"context"
 	"sync"
+	"time"
 )
 
+// DisabledReasonPermissions marks an auto-channel skipped because the bot lost
+// Connect or Speak in it
+const DisabledReasonPermissions = "missing_permissions"
+
 // State represents the state of radio for a guild
 type State struct {
 	Active             bool
 	ChannelID          string
-	AutoChannelID      string // Channel ID for auto-join when users are present
-	AutoConnectEnabled bool   // Whether auto-connect is enabled
+	AutoChannels       []AutoChannel // Channels for auto-join when users are present
+	AutoChannelRule    string        // How to pick among occupied auto-channels
+	AutoConnectEnabled bool          // Whether auto-connect is enabled
+	AutoConnectRules   AutoConnectRules
 	ReconnectAttempts  int
+	IdleGrace          time.Duration  // How long to stay in an empty channel, negative means use the default
+	IdleMute           bool           // Whether to stop sending audio while waiting in an empty channel
+	ListenerPolicy     ListenerPolicy // Who counts as a listener of the channel
+	idleTimer          *time.Timer    // Pending leave while the channel is empty
+	idleMuted          bool           // Whether audio is muted until the pending leave
+	FollowUserID       string         // User whose voice channel the radio follows, empty if none
+	Station            string         // Station URL played in the guild, empty means RADIO_URL
+	Volume             int            // Volume in percent, negative means DefaultVolume
+	session            *Session       // Playing session to resume after a restart
+	failingSince       time.Time      // When the radio lost its voice connection while active, zero if healthy
+	connecting         bool           // Whether a connect attempt is in progress
+	streamID           uint64         // Generation of the current stream
+	streamCancel       context.CancelFunc
 	mu                 sync.Mutex
 }
 
@@ -20,6 +41,9 @@ func NewState() *State {
 		Active:            false,
 		ChannelID:         "",
 		ReconnectAttempts: 0,
+		IdleGrace:         -1,
+		Volume:            -1,
+		AutoChannelRule:   RuleMostListeners,
 	}
 }
 
@@ -28,6 +52,33 @@ func (s *State) SetActive(active bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.Active = active
+	if !active {
+		s.failingSince = time.Time{}
+	}
+}
+
+// MarkFailing records that the active radio lost its voice connection
+// Keeps the time of the first failure until ClearFailing
+func (s *State) MarkFailing() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failingSince.IsZero() {
+		s.failingSince = time.Now()
+	}
+}
+
+// ClearFailing records that the radio plays again
+func (s *State) ClearFailing() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failingSince = time.Time{}
+}
+
+// FailingSince returns when the radio started failing, zero if it isn't
+func (s *State) FailingSince() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failingSince
 }
 
 // IsActive returns whether radio is active
@@ -80,21 +131,85 @@ func (s *State) Reset() {
 	s.Active = false
 	s.ChannelID = ""
 	s.ReconnectAttempts = 0
-	// Note: AutoChannelID is NOT reset, so it persists
+	// Note: AutoChannels are NOT reset, so they persist
+}
+
+// SetAutoChannels replaces the auto-join channels
+func (s *State) SetAutoChannels(channels []AutoChannel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.AutoChannels = append([]AutoChannel(nil), channels...)
+}
+
+// GetAutoChannels returns a copy of the auto-join channels
+func (s *State) GetAutoChannels() []AutoChannel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AutoChannel(nil), s.AutoChannels...)
+}
+
+// AddAutoChannel adds an auto-join channel or updates its priority if already present
+func (s *State) AddAutoChannel(channelID string, priority int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.AutoChannels {
+		if s.AutoChannels[i].ChannelID == channelID {
+			s.AutoChannels[i].Priority = priority
+			s.AutoChannels[i].DisabledReason = ""
+			return
+		}
+	}
+	s.AutoChannels = append(s.AutoChannels, AutoChannel{ChannelID: channelID, Priority: priority})
+}
+
+// RemoveAutoChannel removes an auto-join channel; returns whether it was present
+func (s *State) RemoveAutoChannel(channelID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.AutoChannels {
+		if s.AutoChannels[i].ChannelID == channelID {
+			s.AutoChannels = append(s.AutoChannels[:i:i], s.AutoChannels[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// IsAutoChannel returns whether a channel is one of the auto-join channels
+func (s *State) IsAutoChannel(channelID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, channel := range s.AutoChannels {
+		if channel.ChannelID == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAutoChannelDisabledReason marks an auto-join channel as skipped, or usable with an empty reason
+func (s *State) SetAutoChannelDisabledReason(channelID, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.AutoChannels {
+		if s.AutoChannels[i].ChannelID == channelID {
+			s.AutoChannels[i].DisabledReason = reason
+		}
+	}
 }
 
-// SetAutoChannelID sets the auto-join channel ID
-func (s *State) SetAutoChannelID(channelID string) {
+// SetAutoChannelRule sets how to pick among occupied auto-channels
+func (s *State) SetAutoChannelRule(rule string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.AutoChannelID = channelID
+	s.AutoChannelRule = rule
 }
 
-// GetAutoChannelID returns the auto-join channel ID
-func (s *State) GetAutoChannelID() string {
+// GetAutoChannelRule returns how to pick among occupied auto-channels
+func (s *State) GetAutoChannelRule() string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.AutoChannelID
+	return s.AutoChannelRule
 }
 
 // SetAutoConnectEnabled sets whether auto-connect is enabled
@@ -110,3 +225,229 @@ func (s *State) IsAutoConnectEnabled() bool {
 	defer s.mu.Unlock()
 	return s.AutoConnectEnabled
 }
+
+// SetIdleGrace sets how long to stay in an empty channel, negative means use the default
+func (s *State) SetIdleGrace(grace time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.IdleGrace = grace
+}
+
+// GetIdleGrace returns the idle grace period, negative means use the default
+func (s *State) GetIdleGrace() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.IdleGrace
+}
+
+// SetIdleMute sets whether audio is muted while waiting in an empty channel
+func (s *State) SetIdleMute(mute bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.IdleMute = mute
+}
+
+// IsIdleMute returns whether audio is muted while waiting in an empty channel
+func (s *State) IsIdleMute() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.IdleMute
+}
+
+// StartIdle records a pending leave, muting audio meanwhile if mute is set
+// Returns false if a leave is already pending
+func (s *State) StartIdle(timer *time.Timer, mute bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.idleTimer != nil {
+		return false
+	}
+	s.idleTimer = timer
+	s.idleMuted = mute
+	return true
+}
+
+// StopIdle cancels a pending leave; returns whether one was pending
+func (s *State) StopIdle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.idleTimer == nil {
+		return false
+	}
+	s.idleTimer.Stop()
+	s.idleTimer = nil
+	return true
+}
+
+// IsIdle returns whether a leave is pending because the channel is empty
+func (s *State) IsIdle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.idleTimer != nil
+}
+
+// IsMuted returns whether audio should not be sent right now
+func (s *State) IsMuted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.idleTimer != nil && s.idleMuted
+}
+
+// BeginConnect marks a connect attempt as started; returns false if one is already running
+func (s *State) BeginConnect() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connecting {
+		return false
+	}
+	s.connecting = true
+	return true
+}
+
+// EndConnect marks a connect attempt as finished
+func (s *State) EndConnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connecting = false
+}
+
+// GetListenerPolicy returns a copy of the listener policy
+func (s *State) GetListenerPolicy() ListenerPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ListenerPolicy.Clone()
+}
+
+// SetListenerPolicy sets the listener policy
+func (s *State) SetListenerPolicy(policy ListenerPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ListenerPolicy = policy.Clone()
+}
+
+// SetCountDeafened sets whether deafened members count as listeners
+func (s *State) SetCountDeafened(count bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ListenerPolicy.CountDeafened = count
+}
+
+// SetUserIgnored adds or removes a user from the ignored listeners
+func (s *State) SetUserIgnored(userID string, ignored bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ignored {
+		s.ListenerPolicy.IgnoredUsers = addUnique(s.ListenerPolicy.IgnoredUsers, userID)
+	} else {
+		s.ListenerPolicy.IgnoredUsers = without(s.ListenerPolicy.IgnoredUsers, userID)
+	}
+}
+
+// SetRoleIgnored adds or removes a role from the ignored listener roles
+func (s *State) SetRoleIgnored(roleID string, ignored bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ignored {
+		s.ListenerPolicy.IgnoredRoles = addUnique(s.ListenerPolicy.IgnoredRoles, roleID)
+	} else {
+		s.ListenerPolicy.IgnoredRoles = without(s.ListenerPolicy.IgnoredRoles, roleID)
+	}
+}
+
+// StartStream registers a new stream, cancelling the previous one
+// Returns the stream generation to check with IsCurrentStream
+func (s *State) StartStream(cancel context.CancelFunc) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streamCancel != nil {
+		s.streamCancel()
+	}
+	s.streamID++
+	s.streamCancel = cancel
+	return s.streamID
+}
+
+// StopStream cancels the current stream, if any
+// The stopped stream is no longer current, so its end doesn't trigger a reconnect
+func (s *State) StopStream() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streamCancel != nil {
+		s.streamCancel()
+		s.streamCancel = nil
+	}
+	s.streamID++
+}
+
+// IsCurrentStream returns whether the stream generation hasn't been replaced
+func (s *State) IsCurrentStream(id uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streamID == id
+}
+
+// GetAutoConnectRules returns a copy of the auto-connect rules
+func (s *State) GetAutoConnectRules() AutoConnectRules {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.AutoConnectRules.Clone()
+}
+
+// SetAutoConnectRules sets the auto-connect rules
+func (s *State) SetAutoConnectRules(rules AutoConnectRules) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.AutoConnectRules = rules.Clone()
+}
+
+// SetFollowUserID sets the user whose voice channel the radio follows, empty to stop following
+func (s *State) SetFollowUserID(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.FollowUserID = userID
+}
+
+// GetFollowUserID returns the user whose voice channel the radio follows
+func (s *State) GetFollowUserID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.FollowUserID
+}
+
+// SetStation sets the station URL played in the guild, empty for RADIO_URL
+func (s *State) SetStation(station string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Station = station
+}
+
+// GetStation returns the station URL played in the guild, empty for RADIO_URL
+func (s *State) GetStation() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Station
+}
+
+// SetVolume sets the volume in percent, negative for DefaultVolume
+func (s *State) SetVolume(percent int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Volume = percent
+}
+
+// GetVolume returns the volume in percent
+func (s *State) GetVolume() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Volume < 0 {
+		return DefaultVolume
+	}
+	return s.Volume
+}
+
+// HasVolume reports whether the guild set its own volume
+func (s *State) HasVolume() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Volume >= 0
+}
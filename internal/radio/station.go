@@ -0,0 +1,30 @@
+package radio
+
+import (
+	"fmt"
+	"net/url"
+)
+
+const (
+	// DefaultVolume is the volume of guilds that didn't set one, in percent
+	DefaultVolume = 100
+	// MaxVolume is the loudest volume a guild may set, in percent
+	MaxVolume = 200
+)
+
+// ValidateStation checks a station URL a guild wants to play
+func ValidateStation(station string) error {
+	u, err := url.Parse(station)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid station %q: expected an http or https URL", station)
+	}
+	return nil
+}
+
+// ValidateVolume checks a volume in percent
+func ValidateVolume(percent int) error {
+	if percent < 0 || percent > MaxVolume {
+		return fmt.Errorf("invalid volume %d: expected 0 to %d percent", percent, MaxVolume)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package radio
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Storage backends selectable in the configuration
+const (
+	StorageJSON    = "json"
+	StorageJournal = "journal"
+	StorageMemory  = "memory"
+)
+
+// Storage persists guild configurations
+type Storage interface {
+	// Load returns every saved guild configuration
+	Load() (map[string]GuildConfig, error)
+	// Save stores the configuration of a guild
+	Save(guildID string, config GuildConfig) error
+	// Delete removes the configuration of a guild
+	Delete(guildID string) error
+	// Close releases the storage
+	Close() error
+}
+
+// OpenArchive opens the archive of guilds the bot left, kept next to the storage backend's files
+func OpenArchive(backend, dir string) (*Archive, error) {
+	if backend == StorageMemory {
+		return NewArchive("")
+	}
+	return NewArchive(filepath.Join(dir, "guild_archive.json"))
+}
+
+// OpenStorage opens a storage backend keeping its files in dir
+func OpenStorage(backend, dir string) (Storage, error) {
+	switch backend {
+	case StorageJSON, "":
+		return NewFileStorage(filepath.Join(dir, "radio_config.json"))
+	case StorageJournal:
+		return NewJournalStorage(filepath.Join(dir, "radio_config.journal"))
+	case StorageMemory:
+		return NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// configFile is the on-disk format of the JSON file storage
+type configFile struct {
+	Version int                    `json:"version"`
+	Guilds  map[string]GuildConfig `json:"guilds"`
+}
+
+// FileStorage keeps all guild configurations in one JSON file,
+// rewritten atomically on every change
+type FileStorage struct {
+	path    string
+	configs map[string]GuildConfig
+	mu      sync.Mutex
+}
+
+// NewFileStorage creates a JSON file storage, creating the file's directory if needed
+func NewFileStorage(path string) (*FileStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &FileStorage{
+		path:    path,
+		configs: make(map[string]GuildConfig),
+	}, nil
+}
+
+// Load reads the JSON file, migrating it to the current schema version
+// Files from a newer version are refused rather than overwritten
+func (f *FileStorage) Load() (map[string]GuildConfig, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		// File doesn't exist yet, that's okay
+		return map[string]GuildConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", f.path, err)
+	}
+
+	guilds, version, err := parseConfigFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", f.path, err)
+	}
+
+	if err := migrateGuilds(guilds, version); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	configs, err := decodeGuilds(guilds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", f.path, err)
+	}
+	f.configs = configs
+
+	if version < SchemaVersion {
+		if err := backupFile(f.path, data, version); err != nil {
+			return nil, err
+		}
+		if err := f.writeUnsafe(); err != nil {
+			return nil, err
+		}
+	}
+
+	return copyConfigs(configs), nil
+}
+
+// Save stores a guild configuration and rewrites the file
+func (f *FileStorage) Save(guildID string, config GuildConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.configs[guildID] = config
+	return f.writeUnsafe()
+}
+
+// Delete removes a guild configuration and rewrites the file
+func (f *FileStorage) Delete(guildID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.configs[guildID]; !exists {
+		return nil
+	}
+	delete(f.configs, guildID)
+	return f.writeUnsafe()
+}
+
+// Close does nothing, the file isn't kept open
+func (f *FileStorage) Close() error {
+	return nil
+}
+
+// writeUnsafe writes all configurations without locking (internal use)
+func (f *FileStorage) writeUnsafe() error {
+	data, err := json.MarshalIndent(configFile{Version: SchemaVersion, Guilds: f.configs}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	return writeFileAtomic(f.path, data)
+}
+
+// parseConfigFile splits a JSON file into raw guild configurations and its schema version
+func parseConfigFile(data []byte) (map[string]rawGuild, int, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, 0, err
+	}
+
+	guilds := make(map[string]rawGuild)
+
+	rawVersion, versioned := top["version"]
+	if !versioned {
+		// Version 1 is a bare map of guild ID to configuration
+		for guildID, raw := range top {
+			var guild rawGuild
+			if err := json.Unmarshal(raw, &guild); err != nil {
+				return nil, 0, fmt.Errorf("guild %s: %w", guildID, err)
+			}
+			guilds[guildID] = guild
+		}
+		return guilds, 1, nil
+	}
+
+	var version int
+	if err := json.Unmarshal(rawVersion, &version); err != nil {
+		return nil, 0, fmt.Errorf("invalid version: %w", err)
+	}
+	if raw, exists := top["guilds"]; exists {
+		if err := json.Unmarshal(raw, &guilds); err != nil {
+			return nil, 0, err
+		}
+	}
+	return guilds, version, nil
+}
+
+// MemoryStorage keeps guild configurations in memory only, e.g. for tests
+type MemoryStorage struct {
+	configs map[string]GuildConfig
+	mu      sync.Mutex
+}
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{configs: make(map[string]GuildConfig)}
+}
+
+// Load returns the stored configurations
+func (s *MemoryStorage) Load() (map[string]GuildConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyConfigs(s.configs), nil
+}
+
+// Save stores a guild configuration
+func (s *MemoryStorage) Save(guildID string, config GuildConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configs[guildID] = config
+	return nil
+}
+
+// Delete removes a guild configuration
+func (s *MemoryStorage) Delete(guildID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.configs, guildID)
+	return nil
+}
+
+// Close does nothing
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// copyConfigs returns a shallow copy of a configuration map
+func copyConfigs(configs map[string]GuildConfig) map[string]GuildConfig {
+	result := make(map[string]GuildConfig, len(configs))
+	for guildID, config := range configs {
+		result[guildID] = config
+	}
+	return result
+}
+
+// writeFileAtomic writes a file through a temp file and a rename,
+// so a crash never leaves a half-written file behind
+func writeFileAtomic(path string, data []byte) error {
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpFile, err)
+	}
+
+	if err := os.Rename(tmpFile, path); err != nil {
+		_ = os.Remove(tmpFile) // Clean up temp file on error
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}